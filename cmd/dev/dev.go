@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -14,6 +15,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/datasektionen/sso/pkg/fakehive"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -54,6 +56,21 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	if fixture, ok := os.LookupEnv("FAKE_HIVE_FIXTURE"); ok {
+		addr := "localhost:6869"
+		if a, ok := os.LookupEnv("FAKE_HIVE_ADDR"); ok {
+			addr = a
+		}
+		go func() {
+			err := http.ListenAndServe(addr, fakehive.FromFile(fixture))
+			fmt.Fprintf(os.Stderr, "Fake hive stopped: %v\n", err)
+		}()
+		// These are inherited by the web process
+		os.Setenv("HIVE_URL", "http://"+addr)
+		os.Setenv("HIVE_API_KEY", "fake")
+		fmt.Printf("Serving fake hive from %s on http://%s\n", fixture, addr)
+	}
+
 	tailwind := "tailwindcss"
 	if t, ok := os.LookupEnv("TAILWIND_PATH"); ok {
 		tailwind = t
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/jobs"
 	"github.com/datasektionen/sso/pkg/kthldap"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/pressly/goose/v3"
@@ -30,9 +32,10 @@ func main() {
 	ctx := context.Background()
 	switch shift() {
 	case "add-user":
-		db, _ := must2(database.Connect(ctx))
+		cfg := must1(config.Load())
+		db, _ := must2(database.Connect(ctx, cfg))
 		kthid := shift()
-		p := must1(kthldap.Lookup(ctx, kthid))
+		p := must1(kthldap.NewClient(cfg, nil).Lookup(ctx, kthid))
 		if p == nil {
 			panic("No such user")
 		}
@@ -47,9 +50,28 @@ func main() {
 			MemberTo:   pgtype.Date{Time: time.Now().AddDate(1, 0, 0), Valid: true},
 		}))
 	case "goose":
-		_, db := must2(database.Connect(ctx))
+		_, db := must2(database.Connect(ctx, must1(config.Load())))
 		gooseCMD := shift()
 		must0(goose.RunContext(context.Background(), gooseCMD, db(), "database/migrations", args...))
+	case "run-job":
+		cfg := must1(config.Load())
+		db, _ := must2(database.Connect(ctx, cfg))
+		name := shift()
+		must0(jobs.NewScheduler(db, jobs.Cleanup(cfg)...).RunNow(ctx, name))
+		slog.Info("Job finished", "job", name)
+	case "jobs":
+		cfg := must1(config.Load())
+		db, _ := must2(database.Connect(ctx, cfg))
+		for _, status := range must1(jobs.NewScheduler(db, jobs.Cleanup(cfg)...).Status(ctx)) {
+			lastRun := "never"
+			if !status.LastStartedAt.IsZero() {
+				lastRun = status.LastStartedAt.Format(time.DateTime) + " (took " + status.LastFinishedAt.Sub(status.LastStartedAt).String() + ")"
+			}
+			fmt.Printf("%s\tevery %s\tlast run: %s\n", status.Name, status.Interval, lastRun)
+			if status.LastError != "" {
+				fmt.Printf("\tlast error: %s\n", status.LastError)
+			}
+		}
 	case "gen-oidc-provider-key":
 		key := must1(rsa.GenerateKey(rand.Reader, 4096))
 		if len(key.Primes) != 2 {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
@@ -13,19 +14,36 @@ import (
 	"github.com/datasektionen/sso/database"
 	"github.com/datasektionen/sso/handlers"
 	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/pkg/logging"
+	"github.com/datasektionen/sso/pkg/metrics"
 	"github.com/datasektionen/sso/pkg/oidcprovider"
 	"github.com/datasektionen/sso/pkg/static"
+	"github.com/datasektionen/sso/pkg/tracing"
 	"github.com/datasektionen/sso/service"
 )
 
 func main() {
+	slog.SetDefault(slog.New(logging.NewHandler(slog.NewTextHandler(os.Stderr, nil))))
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
 	initCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	db, err := database.ConnectAndMigrate(initCtx)
+	// We never shut down gracefully, so spans that haven't been exported when the process is
+	// killed are lost.
+	if _, err := tracing.Init(initCtx, cfg); err != nil {
+		panic(err)
+	}
+	db, err := database.ConnectAndMigrate(initCtx, cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	s, err := service.NewService(initCtx, db)
+	s, err := service.NewService(initCtx, cfg, db)
 	if err != nil {
 		panic(err)
 	}
@@ -35,10 +53,12 @@ func main() {
 	}
 	cancel()
 
-	if config.Config.PortExternal != 0 {
-		go serve(s, op, false, config.Config.PortExternal)
+	s.Jobs.Start(context.Background())
+
+	if cfg.PortExternal != 0 {
+		go serve(s, op, false, cfg.PortExternal)
 	}
-	serve(s, op, true, config.Config.PortInternal)
+	serve(s, op, true, cfg.PortInternal)
 }
 
 func serve(s *service.Service, op http.Handler, internal bool, p int) {
@@ -58,9 +78,9 @@ func serve(s *service.Service, op http.Handler, internal bool, p int) {
 		started = "Internal server started"
 	}
 	slog.Info(started, "address", "http://localhost:"+port)
-	if config.Config.Dev {
-		slog.Info("Using configuration", "config", config.Config)
+	if s.Cfg.Dev {
+		slog.Info("Using configuration", "config", s.Cfg)
 	}
-	slog.Error("Failed serving http server", "error", http.Serve(l, mux))
+	slog.Error("Failed serving http server", "error", http.Serve(l, logging.Middleware(httputil.SecurityHeaders(s.Cfg, tracing.Middleware(metrics.Middleware(logging.AccessLog(mux)))))))
 	os.Exit(1)
 }
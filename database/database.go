@@ -6,6 +6,7 @@ import (
 	"embed"
 
 	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/tracing"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
@@ -17,8 +18,13 @@ import (
 //go:embed migrations/*.sql
 var migrations embed.FS
 
-func Connect(ctx context.Context) (*Queries, func() *sql.DB, error) {
-	pool, err := pgxpool.New(ctx, config.Config.DatabaseURL.String())
+func Connect(ctx context.Context, cfg *config.Cfg) (*Queries, func() *sql.DB, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.DatabaseURL.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	poolCfg.ConnConfig.Tracer = tracing.QueryTracer{}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -26,8 +32,8 @@ func Connect(ctx context.Context) (*Queries, func() *sql.DB, error) {
 	return New(pool), func() *sql.DB { return stdlib.OpenDBFromPool(pool) }, nil
 }
 
-func ConnectAndMigrate(ctx context.Context) (*Queries, error) {
-	q, db, err := Connect(ctx)
+func ConnectAndMigrate(ctx context.Context, cfg *config.Cfg) (*Queries, error) {
+	q, db, err := Connect(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
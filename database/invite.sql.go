@@ -42,6 +42,19 @@ func (q *Queries) CreateInvite(ctx context.Context, arg CreateInviteParams) (Inv
 	return i, err
 }
 
+const deleteExpiredInvites = `-- name: DeleteExpiredInvites :execrows
+delete from invites
+where expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredInvites(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredInvites)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteInvite = `-- name: DeleteInvite :exec
 delete from invites
 where id = $1
@@ -27,6 +27,19 @@ func (q *Queries) CreateToken(ctx context.Context, kthid string) (uuid.UUID, err
 	return id, err
 }
 
+const deleteExpiredTokens = `-- name: DeleteExpiredTokens :execrows
+delete from legacyapi_tokens
+where last_used_at <= now() - interval '8 hours'
+`
+
+func (q *Queries) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredTokens)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteToken = `-- name: DeleteToken :exec
 delete from legacyapi_tokens
 where kthid = $1
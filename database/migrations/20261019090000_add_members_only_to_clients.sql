@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table oidc_clients
+add column members_only boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table oidc_clients
+drop column members_only;
+-- +goose StatementEnd
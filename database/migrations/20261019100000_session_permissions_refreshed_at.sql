@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table sessions
+add column permissions_refreshed_at timestamp not null default now();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table sessions
+drop column permissions_refreshed_at;
+-- +goose StatementEnd
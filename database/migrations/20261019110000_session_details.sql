@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table sessions
+add column created_at timestamp not null default now(),
+add column login_method text not null default '',
+add column user_agent text not null default '',
+add column ip_address text not null default '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table sessions
+drop column created_at,
+drop column login_method,
+drop column user_agent,
+drop column ip_address;
+-- +goose StatementEnd
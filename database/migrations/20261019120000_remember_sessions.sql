@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table sessions
+add column remember boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table sessions
+drop column remember;
+-- +goose StatementEnd
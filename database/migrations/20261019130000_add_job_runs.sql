@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+create table job_runs (
+    name text primary key,
+    started_at timestamp not null,
+    finished_at timestamp not null,
+    error text not null default ''
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table job_runs;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+create table audit_log (
+    id uuid primary key default gen_random_uuid(),
+    created_at timestamp not null default now(),
+    actor text null, -- kthid, null if nobody was logged in
+    action text not null,
+    target text not null default '',
+    before jsonb null,
+    after jsonb null,
+    ip_address text not null default ''
+);
+
+create index audit_log_created_at_idx on audit_log (created_at);
+create index audit_log_actor_idx on audit_log (actor);
+create index audit_log_target_idx on audit_log (target);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table audit_log;
+-- +goose StatementEnd
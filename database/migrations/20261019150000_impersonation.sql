@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table sessions
+add column impersonator text null references users (kthid) on delete cascade,
+add column impersonator_session_id uuid null references sessions (id) on delete cascade;
+
+alter table audit_log
+add column impersonated text null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table audit_log
+drop column impersonated;
+
+delete from sessions
+where impersonator is not null;
+
+alter table sessions
+drop column impersonator_session_id,
+drop column impersonator;
+-- +goose StatementEnd
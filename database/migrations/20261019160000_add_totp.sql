@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+create table totp_secrets (
+    kthid text primary key references users (kthid) on delete cascade,
+    -- Base32 encoded, as in the otpauth:// URL.
+    secret text not null,
+    -- Null until the user has entered a code from their authenticator app.
+    confirmed_at timestamp null,
+    created_at timestamp not null default now(),
+    -- The time step of the last accepted code, so that codes can't be reused.
+    last_used_step bigint not null default 0,
+    failed_attempts int not null default 0,
+    last_failed_at timestamp null
+);
+
+create table recovery_codes (
+    id uuid primary key default gen_random_uuid(),
+    kthid text not null references users (kthid) on delete cascade,
+    code_hash bytea not null,
+    created_at timestamp not null default now(),
+    used_at timestamp null
+);
+
+create index on recovery_codes (kthid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table recovery_codes;
+drop table totp_secrets;
+-- +goose StatementEnd
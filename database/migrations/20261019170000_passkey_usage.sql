@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table passkeys
+add column created_at timestamp not null default now(),
+add column last_used_at timestamp null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table passkeys
+drop column created_at,
+drop column last_used_at;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+create table device_logins (
+    -- Only known by the browser that is waiting to be logged in.
+    id uuid primary key default gen_random_uuid(),
+    -- Shown in the QR code and typed in on the other device.
+    code text not null unique,
+    user_agent text not null,
+    ip_address text not null,
+    created_at timestamp not null default now(),
+    approved_by text null references users (kthid) on delete cascade
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table device_logins;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- codes in progress can't be given a link, and they expire within 10 minutes anyway
+delete from email_logins;
+alter table email_logins
+    add column link_token text not null, -- sha256 of the token in the link, hex encoded
+    add column browser text not null; -- the value of the cookie set in the browser that requested the code
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table email_logins
+    drop column link_token,
+    drop column browser;
+-- +goose StatementEnd
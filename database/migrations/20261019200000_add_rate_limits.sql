@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+create table rate_limits (
+    key text primary key, -- policy name and e.g. client ip or kth id
+    count int not null,
+    window_end timestamp not null
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table rate_limits;
+-- +goose StatementEnd
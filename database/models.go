@@ -23,6 +23,27 @@ type AccountRequest struct {
 	Email      string
 }
 
+type AuditLog struct {
+	ID           uuid.UUID
+	CreatedAt    pgtype.Timestamp
+	Actor        pgtype.Text
+	Action       string
+	Target       string
+	Before       []byte
+	After        []byte
+	IpAddress    string
+	Impersonated pgtype.Text
+}
+
+type DeviceLogin struct {
+	ID         uuid.UUID
+	Code       string
+	UserAgent  string
+	IpAddress  string
+	CreatedAt  pgtype.Timestamp
+	ApprovedBy pgtype.Text
+}
+
 type EmailChangeRequest struct {
 	Kthid     string
 	NewEmail  string
@@ -36,6 +57,8 @@ type EmailLogin struct {
 	Code      string
 	CreatedAt pgtype.Timestamp
 	Attempts  int32
+	LinkToken string
+	Browser   string
 }
 
 type Invite struct {
@@ -47,6 +70,13 @@ type Invite struct {
 	CurrentUses int32
 }
 
+type JobRun struct {
+	Name       string
+	StartedAt  pgtype.Timestamp
+	FinishedAt pgtype.Timestamp
+	Error      string
+}
+
 type LastMembershipSheet struct {
 	UniqueMarker string
 	UploadedAt   pgtype.Timestamp
@@ -66,6 +96,7 @@ type OidcClient struct {
 	HiveSystemID string
 	LastUsedAt   pgtype.Timestamp
 	AllowGuests  bool
+	MembersOnly  bool
 }
 
 type Passkey struct {
@@ -74,14 +105,48 @@ type Passkey struct {
 	Kthid        string
 	Data         []byte
 	Discoverable bool
+	CreatedAt    pgtype.Timestamp
+	LastUsedAt   pgtype.Timestamp
+}
+
+type RateLimit struct {
+	Key       string
+	Count     int32
+	WindowEnd pgtype.Timestamp
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	Kthid     string
+	CodeHash  []byte
+	CreatedAt pgtype.Timestamp
+	UsedAt    pgtype.Timestamp
 }
 
 type Session struct {
-	ID          uuid.UUID
-	Kthid       pgtype.Text
-	LastUsedAt  pgtype.Timestamp
-	Permissions []byte
-	GuestData   []byte
+	ID                     uuid.UUID
+	Kthid                  pgtype.Text
+	LastUsedAt             pgtype.Timestamp
+	Permissions            []byte
+	GuestData              []byte
+	PermissionsRefreshedAt pgtype.Timestamp
+	CreatedAt              pgtype.Timestamp
+	LoginMethod            string
+	UserAgent              string
+	IpAddress              string
+	Remember               bool
+	Impersonator           pgtype.Text
+	ImpersonatorSessionID  pgtype.UUID
+}
+
+type TotpSecret struct {
+	Kthid          string
+	Secret         string
+	ConfirmedAt    pgtype.Timestamp
+	CreatedAt      pgtype.Timestamp
+	LastUsedStep   int64
+	FailedAttempts int32
+	LastFailedAt   pgtype.Timestamp
 }
 
 type User struct {
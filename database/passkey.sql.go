@@ -36,8 +36,22 @@ func (q *Queries) AddPasskey(ctx context.Context, arg AddPasskeyParams) (uuid.UU
 	return id, err
 }
 
+const deleteExpiredWebAuthnSessionData = `-- name: DeleteExpiredWebAuthnSessionData :execrows
+delete from webauthn_session_data
+where created_at < now() - interval '1 hour'
+`
+
+// Ceremonies time out after a few minutes, so this leaves plenty of margin.
+func (q *Queries) DeleteExpiredWebAuthnSessionData(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredWebAuthnSessionData)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getPasskey = `-- name: GetPasskey :one
-select id, name, kthid, data, discoverable
+select id, name, kthid, data, discoverable, created_at, last_used_at
 from passkeys
 where kthid = $1 and decode(data->>'id', 'base64') = $2
 `
@@ -56,14 +70,17 @@ func (q *Queries) GetPasskey(ctx context.Context, arg GetPasskeyParams) (Passkey
 		&i.Kthid,
 		&i.Data,
 		&i.Discoverable,
+		&i.CreatedAt,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
 
 const listPasskeysByUser = `-- name: ListPasskeysByUser :many
-select id, name, kthid, data, discoverable
+select id, name, kthid, data, discoverable, created_at, last_used_at
 from passkeys
 where kthid = $1
+order by created_at
 `
 
 func (q *Queries) ListPasskeysByUser(ctx context.Context, kthid string) ([]Passkey, error) {
@@ -81,6 +98,8 @@ func (q *Queries) ListPasskeysByUser(ctx context.Context, kthid string) ([]Passk
 			&i.Kthid,
 			&i.Data,
 			&i.Discoverable,
+			&i.CreatedAt,
+			&i.LastUsedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -92,6 +111,23 @@ func (q *Queries) ListPasskeysByUser(ctx context.Context, kthid string) ([]Passk
 	return items, nil
 }
 
+const recordPasskeyUse = `-- name: RecordPasskeyUse :exec
+update passkeys
+set data = $2, last_used_at = now()
+where id = $1
+`
+
+type RecordPasskeyUseParams struct {
+	ID   uuid.UUID
+	Data []byte
+}
+
+// data is the credential returned when validating the login, which has the updated sign count.
+func (q *Queries) RecordPasskeyUse(ctx context.Context, arg RecordPasskeyUseParams) error {
+	_, err := q.db.Exec(ctx, recordPasskeyUse, arg.ID, arg.Data)
+	return err
+}
+
 const removePasskey = `-- name: RemovePasskey :exec
 delete from passkeys
 where kthid = $1 and id = $2
@@ -107,6 +143,23 @@ func (q *Queries) RemovePasskey(ctx context.Context, arg RemovePasskeyParams) er
 	return err
 }
 
+const renamePasskey = `-- name: RenamePasskey :exec
+update passkeys
+set name = $3
+where kthid = $1 and id = $2
+`
+
+type RenamePasskeyParams struct {
+	Kthid string
+	ID    uuid.UUID
+	Name  string
+}
+
+func (q *Queries) RenamePasskey(ctx context.Context, arg RenamePasskeyParams) error {
+	_, err := q.db.Exec(ctx, renamePasskey, arg.Kthid, arg.ID, arg.Name)
+	return err
+}
+
 const storeWebAuthnSessionData = `-- name: StoreWebAuthnSessionData :one
 insert into webauthn_session_data (data, kthid)
 values ($1, $2)
@@ -128,6 +181,7 @@ func (q *Queries) StoreWebAuthnSessionData(ctx context.Context, arg StoreWebAuth
 const takeWebAuthnSessionData = `-- name: TakeWebAuthnSessionData :one
 delete from webauthn_session_data
 where id = $1
+and created_at >= now() - interval '1 hour'
 returning data, kthid
 `
 
@@ -136,6 +190,8 @@ type TakeWebAuthnSessionDataRow struct {
 	Kthid string
 }
 
+// Rows older than what DeleteExpiredWebAuthnSessionData removes may still be around if the job
+// hasn't run yet, but shouldn't be used.
 func (q *Queries) TakeWebAuthnSessionData(ctx context.Context, id uuid.UUID) (TakeWebAuthnSessionDataRow, error) {
 	row := q.db.QueryRow(ctx, takeWebAuthnSessionData, id)
 	var i TakeWebAuthnSessionDataRow
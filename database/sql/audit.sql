@@ -0,0 +1,34 @@
+-- name: InsertAuditLog :exec
+insert into audit_log (actor, action, target, before, after, ip_address, impersonated)
+values ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAuditLog :many
+select *
+from audit_log
+where (@actor::text = '' or actor = @actor)
+and (@action::text = '' or action = @action)
+and (@target::text = '' or target = @target)
+and (sqlc.narg(since)::timestamp is null or created_at >= sqlc.narg(since))
+and (sqlc.narg(until)::timestamp is null or created_at < sqlc.narg(until))
+order by created_at desc, id desc
+limit $1
+offset $2;
+
+-- name: ListAuditLogBefore :many
+-- Like ListAuditLog, but paginated by the last entry of the previous page instead of an offset, so
+-- that going through all of it doesn't get slower the further back it gets.
+select *
+from audit_log
+where (@actor::text = '' or actor = @actor)
+and (@action::text = '' or action = @action)
+and (@target::text = '' or target = @target)
+and (sqlc.narg(since)::timestamp is null or created_at >= sqlc.narg(since))
+and (sqlc.narg(until)::timestamp is null or created_at < sqlc.narg(until))
+and (sqlc.narg(before_id)::uuid is null or (created_at, id) < (sqlc.narg(before_created_at)::timestamp, sqlc.narg(before_id)))
+order by created_at desc, id desc
+limit @page_size;
+
+-- name: ListAuditActions :many
+select distinct action
+from audit_log
+order by action;
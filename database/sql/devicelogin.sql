@@ -0,0 +1,39 @@
+-- name: CreateDeviceLogin :one
+insert into device_logins (code, user_agent, ip_address)
+values ($1, $2, $3)
+returning id;
+
+-- name: GetDeviceLogin :one
+select *
+from device_logins
+where id = $1
+and created_at > now() - interval '5 minutes';
+
+-- name: GetDeviceLoginByCode :one
+select *
+from device_logins
+where code = $1
+and approved_by is null
+and created_at > now() - interval '5 minutes';
+
+-- name: ApproveDeviceLogin :execrows
+update device_logins
+set approved_by = $2
+where code = $1
+and approved_by is null
+and created_at > now() - interval '5 minutes';
+
+-- name: DenyDeviceLogin :execrows
+delete from device_logins
+where code = $1
+and approved_by is null;
+
+-- name: TakeApprovedDeviceLogin :one
+delete from device_logins
+where id = $1
+and approved_by is not null
+returning approved_by;
+
+-- name: DeleteExpiredDeviceLogins :execrows
+delete from device_logins
+where created_at < now() - interval '5 minutes';
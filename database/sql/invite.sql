@@ -31,3 +31,7 @@ update invites
 set name = $2, expires_at = $3, max_uses = $4
 where id = $1
 returning *;
+
+-- name: DeleteExpiredInvites :execrows
+delete from invites
+where expires_at < now();
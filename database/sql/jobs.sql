@@ -0,0 +1,24 @@
+-- name: TryJobLock :one
+-- Only lasts until the end of the transaction.
+select pg_try_advisory_xact_lock(hashtext('sso-job:' || @name::text));
+
+-- name: JobRanRecently :one
+select exists (
+    select 1
+    from job_runs
+    where name = @name
+    and started_at > now() - make_interval(secs => @interval_seconds::int)
+);
+
+-- name: RecordJobRun :exec
+insert into job_runs (name, started_at, finished_at, error)
+values (@name, clock_timestamp() - make_interval(secs => @duration_seconds::float8), clock_timestamp(), @error)
+on conflict (name)
+do update
+set started_at = excluded.started_at,
+    finished_at = excluded.finished_at,
+    error = excluded.error;
+
+-- name: ListJobRuns :many
+select *
+from job_runs;
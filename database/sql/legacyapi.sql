@@ -15,3 +15,7 @@ returning kthid;
 -- name: DeleteToken :exec
 delete from legacyapi_tokens
 where kthid = $1;
+
+-- name: DeleteExpiredTokens :execrows
+delete from legacyapi_tokens
+where last_used_at <= now() - interval '8 hours';
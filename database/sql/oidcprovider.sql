@@ -39,3 +39,9 @@ returning *;
 -- name: DeleteClient :exec
 delete from oidc_clients
 where id = $1;
+
+-- name: UpdateClientMembersOnly :one
+update oidc_clients
+set members_only = $2
+where id = $1
+returning *;
@@ -7,10 +7,22 @@ returning id;
 delete from passkeys
 where kthid = $1 and id = $2;
 
+-- name: RenamePasskey :exec
+update passkeys
+set name = $3
+where kthid = $1 and id = $2;
+
+-- name: RecordPasskeyUse :exec
+-- data is the credential returned when validating the login, which has the updated sign count.
+update passkeys
+set data = $2, last_used_at = now()
+where id = $1;
+
 -- name: ListPasskeysByUser :many
 select *
 from passkeys
-where kthid = $1;
+where kthid = $1
+order by created_at;
 
 -- name: GetPasskey :one
 select *
@@ -23,6 +35,14 @@ values ($1, $2)
 returning id;
 
 -- name: TakeWebAuthnSessionData :one
+-- Rows older than what DeleteExpiredWebAuthnSessionData removes may still be around if the job
+-- hasn't run yet, but shouldn't be used.
 delete from webauthn_session_data
 where id = $1
+and created_at >= now() - interval '1 hour'
 returning data, kthid;
+
+-- name: DeleteExpiredWebAuthnSessionData :execrows
+-- Ceremonies time out after a few minutes, so this leaves plenty of margin.
+delete from webauthn_session_data
+where created_at < now() - interval '1 hour';
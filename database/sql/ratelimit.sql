@@ -0,0 +1,14 @@
+-- name: HitRateLimit :one
+-- HitRateLimit counts a request for the key, starting a new window if the last one has ended, and
+-- returns the number of requests in the current window and how many seconds are left of it.
+insert into rate_limits (key, count, window_end)
+values (@key, 1, now() + make_interval(secs => @window_seconds::int))
+on conflict (key)
+do update
+set count = case when rate_limits.window_end <= now() then 1 else rate_limits.count + 1 end,
+    window_end = case when rate_limits.window_end <= now() then excluded.window_end else rate_limits.window_end end
+returning count, ceil(extract(epoch from window_end - now()))::int as seconds_left;
+
+-- name: DeleteExpiredRateLimits :execrows
+delete from rate_limits
+where window_end <= now();
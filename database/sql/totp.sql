@@ -0,0 +1,66 @@
+-- name: GetTOTP :one
+select *
+from totp_secrets
+where kthid = $1;
+
+-- name: BeginTOTPEnrollment :execrows
+-- Does nothing if the user already has a confirmed secret, which has to be removed first.
+insert into totp_secrets (kthid, secret)
+values ($1, $2)
+on conflict (kthid)
+do update
+set secret = $2, created_at = now(), last_used_step = 0, failed_attempts = 0, last_failed_at = null
+where totp_secrets.confirmed_at is null;
+
+-- name: ConfirmTOTP :execrows
+update totp_secrets
+set confirmed_at = now(), last_used_step = $2
+where kthid = $1
+and confirmed_at is null;
+
+-- name: GetTOTPForLogin :one
+select
+    secret,
+    last_used_step,
+    coalesce(failed_attempts >= @max_attempts::int and last_failed_at > now() - make_interval(secs => @lockout_seconds::int), false)::boolean as locked
+from totp_secrets
+where kthid = @kthid
+and confirmed_at is not null;
+
+-- name: RecordTOTPUse :execrows
+-- Does nothing if a code from this or a later time step has already been used, so codes can't be
+-- replayed.
+update totp_secrets
+set last_used_step = $2, failed_attempts = 0
+where kthid = $1
+and last_used_step < $2;
+
+-- name: RecordTOTPFailure :exec
+update totp_secrets
+set failed_attempts = failed_attempts + 1, last_failed_at = now()
+where kthid = $1;
+
+-- name: RemoveTOTP :execrows
+delete from totp_secrets
+where kthid = $1;
+
+-- name: AddRecoveryCode :exec
+insert into recovery_codes (kthid, code_hash)
+values ($1, $2);
+
+-- name: RemoveRecoveryCodes :exec
+delete from recovery_codes
+where kthid = $1;
+
+-- name: UseRecoveryCode :execrows
+update recovery_codes
+set used_at = now()
+where kthid = $1
+and code_hash = $2
+and used_at is null;
+
+-- name: CountRecoveryCodes :one
+select count(*)
+from recovery_codes
+where kthid = $1
+and used_at is null;
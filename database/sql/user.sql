@@ -1,24 +1,74 @@
 -- name: CreateSession :one
-insert into sessions (kthid, permissions)
-values ($1, $2)
+insert into sessions (kthid, permissions, login_method, user_agent, ip_address, remember)
+values ($1, $2, $3, $4, $5, $6)
 returning id;
 
 -- name: CreateGuestSession :one
-insert into sessions (guest_data, permissions)
-values ($1, $2)
+insert into sessions (guest_data, permissions, login_method, user_agent, ip_address, remember)
+values ($1, $2, $3, $4, $5, $6)
 returning id;
 
 -- name: GetSession :one
 update sessions
 set last_used_at = now()
+where id = @id
+and last_used_at > now() - make_interval(secs => case when remember then @remembered_idle_timeout::int else @idle_timeout::int end)
+and created_at > now() - make_interval(secs => case when remember then @remembered_max_age::int else @max_age::int end)
+returning kthid, guest_data, permissions, permissions_refreshed_at < now() - make_interval(secs => @permissions_max_age::int) as permissions_stale, impersonator;
+
+-- name: CreateImpersonationSession :one
+-- Impersonated sessions get no SSO permissions, so an admin can't use someone else's.
+insert into sessions (kthid, permissions, login_method, user_agent, ip_address, impersonator, impersonator_session_id)
+values ($1, '{}', 'impersonation', $2, $3, $4, $5)
+returning id;
+
+-- name: EndImpersonation :one
+delete from sessions
 where id = $1
-and last_used_at > now() - interval '8 hours'
-returning kthid, guest_data, permissions;
+and impersonator is not null
+returning impersonator_session_id;
+
+-- name: GetSessionSecondsLeft :one
+select extract(epoch from created_at + make_interval(secs => case when remember then @remembered_max_age::int else @max_age::int end) - now())::int as seconds_left
+from sessions
+where id = @id;
+
+-- name: SetSessionPermissionsForUser :exec
+update sessions
+set permissions = $2,
+    permissions_refreshed_at = now()
+where kthid = $1
+and impersonator is null;
+
+-- name: ClaimSessionPermissionsRefresh :execrows
+-- Marks the user's stale sessions as refreshed before asking Hive, so that only one request starts
+-- a refresh and one that fails isn't retried until the permissions are stale again.
+update sessions
+set permissions_refreshed_at = now()
+where kthid = @kthid
+and impersonator is null
+and permissions_refreshed_at < now() - make_interval(secs => @permissions_max_age::int);
 
 -- name: RemoveSession :exec
 delete from sessions
 where id = $1;
 
+-- name: ListSessionsForUser :many
+select id, created_at, last_used_at, login_method, user_agent, ip_address, remember, impersonator
+from sessions
+where kthid = @kthid
+and last_used_at > now() - make_interval(secs => case when remember then @remembered_idle_timeout::int else @idle_timeout::int end)
+and created_at > now() - make_interval(secs => case when remember then @remembered_max_age::int else @max_age::int end)
+order by last_used_at desc;
+
+-- name: RemoveSessionForUser :execrows
+delete from sessions
+where id = $1 and kthid = $2;
+
+-- name: RemoveSessionsForUser :exec
+delete from sessions
+where kthid = $1;
+
 -- name: CreateUser :exec
 insert into users (
     kthid,
@@ -172,11 +222,23 @@ where id = $1
 returning *;
 
 -- name: BeginEmailLogin :exec
-insert into email_logins (kthid, code)
-values ($1, $2)
+insert into email_logins (kthid, code, link_token, browser)
+values ($1, $2, $3, $4)
 on conflict (kthid)
 do update
-set code = $2;
+set code = $2, link_token = $3, browser = $4;
+
+-- name: GetEmailLoginByLink :one
+select *
+from email_logins
+where link_token = $1
+and created_at > now() - interval '10 minutes';
+
+-- name: FinishEmailLoginByLink :one
+delete from email_logins
+where link_token = $1
+and created_at > now() - interval '10 minutes'
+returning kthid;
 
 -- name: FinishEmailLogin :one
 with deleted_expired as (
@@ -294,3 +356,16 @@ update users
 set email = $2
 where kthid = $1
 returning *;
+
+-- name: DeleteExpiredSessions :execrows
+delete from sessions
+where last_used_at <= now() - make_interval(secs => case when remember then @remembered_idle_timeout::int else @idle_timeout::int end)
+or created_at <= now() - make_interval(secs => case when remember then @remembered_max_age::int else @max_age::int end);
+
+-- name: DeleteExpiredEmailLogins :execrows
+delete from email_logins
+where created_at < now() - interval '10 minutes';
+
+-- name: DeleteExpiredEmailChangeRequests :execrows
+delete from email_change_requests
+where created_at < now() - interval '1 hour';
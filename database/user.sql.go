@@ -91,20 +91,27 @@ func (q *Queries) BeginEmailChange(ctx context.Context, arg BeginEmailChangePara
 }
 
 const beginEmailLogin = `-- name: BeginEmailLogin :exec
-insert into email_logins (kthid, code)
-values ($1, $2)
+insert into email_logins (kthid, code, link_token, browser)
+values ($1, $2, $3, $4)
 on conflict (kthid)
 do update
-set code = $2
+set code = $2, link_token = $3, browser = $4
 `
 
 type BeginEmailLoginParams struct {
-	Kthid string
-	Code  string
+	Kthid     string
+	Code      string
+	LinkToken string
+	Browser   string
 }
 
 func (q *Queries) BeginEmailLogin(ctx context.Context, arg BeginEmailLoginParams) error {
-	_, err := q.db.Exec(ctx, beginEmailLogin, arg.Kthid, arg.Code)
+	_, err := q.db.Exec(ctx, beginEmailLogin,
+		arg.Kthid,
+		arg.Code,
+		arg.LinkToken,
+		arg.Browser,
+	)
 	return err
 }
 
@@ -118,6 +125,29 @@ func (q *Queries) CancelEmailChange(ctx context.Context, kthid string) error {
 	return err
 }
 
+const claimSessionPermissionsRefresh = `-- name: ClaimSessionPermissionsRefresh :execrows
+update sessions
+set permissions_refreshed_at = now()
+where kthid = $1
+and impersonator is null
+and permissions_refreshed_at < now() - make_interval(secs => $2::int)
+`
+
+type ClaimSessionPermissionsRefreshParams struct {
+	Kthid             pgtype.Text
+	PermissionsMaxAge int32
+}
+
+// Marks the user's stale sessions as refreshed before asking Hive, so that only one request starts
+// a refresh and one that fails isn't retried until the permissions are stale again.
+func (q *Queries) ClaimSessionPermissionsRefresh(ctx context.Context, arg ClaimSessionPermissionsRefreshParams) (int64, error) {
+	result, err := q.db.Exec(ctx, claimSessionPermissionsRefresh, arg.Kthid, arg.PermissionsMaxAge)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createAccountRequest = `-- name: CreateAccountRequest :one
 insert into account_requests (done, reference, reason, year_tag)
 values (false, $1, $2, $3)
@@ -171,36 +201,86 @@ func (q *Queries) CreateAccountRequestManual(ctx context.Context, arg CreateAcco
 }
 
 const createGuestSession = `-- name: CreateGuestSession :one
-insert into sessions (guest_data, permissions)
-values ($1, $2)
+insert into sessions (guest_data, permissions, login_method, user_agent, ip_address, remember)
+values ($1, $2, $3, $4, $5, $6)
 returning id
 `
 
 type CreateGuestSessionParams struct {
 	GuestData   []byte
 	Permissions []byte
+	LoginMethod string
+	UserAgent   string
+	IpAddress   string
+	Remember    bool
 }
 
 func (q *Queries) CreateGuestSession(ctx context.Context, arg CreateGuestSessionParams) (uuid.UUID, error) {
-	row := q.db.QueryRow(ctx, createGuestSession, arg.GuestData, arg.Permissions)
+	row := q.db.QueryRow(ctx, createGuestSession,
+		arg.GuestData,
+		arg.Permissions,
+		arg.LoginMethod,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.Remember,
+	)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createImpersonationSession = `-- name: CreateImpersonationSession :one
+insert into sessions (kthid, permissions, login_method, user_agent, ip_address, impersonator, impersonator_session_id)
+values ($1, '{}', 'impersonation', $2, $3, $4, $5)
+returning id
+`
+
+type CreateImpersonationSessionParams struct {
+	Kthid                 pgtype.Text
+	UserAgent             string
+	IpAddress             string
+	Impersonator          pgtype.Text
+	ImpersonatorSessionID pgtype.UUID
+}
+
+// Impersonated sessions get no SSO permissions, so an admin can't use someone else's.
+func (q *Queries) CreateImpersonationSession(ctx context.Context, arg CreateImpersonationSessionParams) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, createImpersonationSession,
+		arg.Kthid,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.Impersonator,
+		arg.ImpersonatorSessionID,
+	)
 	var id uuid.UUID
 	err := row.Scan(&id)
 	return id, err
 }
 
 const createSession = `-- name: CreateSession :one
-insert into sessions (kthid, permissions)
-values ($1, $2)
+insert into sessions (kthid, permissions, login_method, user_agent, ip_address, remember)
+values ($1, $2, $3, $4, $5, $6)
 returning id
 `
 
 type CreateSessionParams struct {
 	Kthid       pgtype.Text
 	Permissions []byte
+	LoginMethod string
+	UserAgent   string
+	IpAddress   string
+	Remember    bool
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (uuid.UUID, error) {
-	row := q.db.QueryRow(ctx, createSession, arg.Kthid, arg.Permissions)
+	row := q.db.QueryRow(ctx, createSession,
+		arg.Kthid,
+		arg.Permissions,
+		arg.LoginMethod,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.Remember,
+	)
 	var id uuid.UUID
 	err := row.Scan(&id)
 	return id, err
@@ -267,6 +347,58 @@ func (q *Queries) DeleteAccountRequest(ctx context.Context, id uuid.UUID) (Accou
 	return i, err
 }
 
+const deleteExpiredEmailChangeRequests = `-- name: DeleteExpiredEmailChangeRequests :execrows
+delete from email_change_requests
+where created_at < now() - interval '1 hour'
+`
+
+func (q *Queries) DeleteExpiredEmailChangeRequests(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredEmailChangeRequests)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredEmailLogins = `-- name: DeleteExpiredEmailLogins :execrows
+delete from email_logins
+where created_at < now() - interval '10 minutes'
+`
+
+func (q *Queries) DeleteExpiredEmailLogins(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredEmailLogins)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+delete from sessions
+where last_used_at <= now() - make_interval(secs => case when remember then $1::int else $2::int end)
+or created_at <= now() - make_interval(secs => case when remember then $3::int else $4::int end)
+`
+
+type DeleteExpiredSessionsParams struct {
+	RememberedIdleTimeout int32
+	IdleTimeout           int32
+	RememberedMaxAge      int32
+	MaxAge                int32
+}
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, arg DeleteExpiredSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSessions,
+		arg.RememberedIdleTimeout,
+		arg.IdleTimeout,
+		arg.RememberedMaxAge,
+		arg.MaxAge,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const denyNameChangeRequest = `-- name: DenyNameChangeRequest :exec
 update users
 set first_name_change_request = '',
@@ -279,6 +411,20 @@ func (q *Queries) DenyNameChangeRequest(ctx context.Context, kthid string) error
 	return err
 }
 
+const endImpersonation = `-- name: EndImpersonation :one
+delete from sessions
+where id = $1
+and impersonator is not null
+returning impersonator_session_id
+`
+
+func (q *Queries) EndImpersonation(ctx context.Context, id uuid.UUID) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, endImpersonation, id)
+	var impersonator_session_id pgtype.UUID
+	err := row.Scan(&impersonator_session_id)
+	return impersonator_session_id, err
+}
+
 const finishAccountRequestKTH = `-- name: FinishAccountRequestKTH :exec
 update account_requests
 set
@@ -443,6 +589,20 @@ func (q *Queries) FinishEmailLogin(ctx context.Context, arg FinishEmailLoginPara
 	return i, err
 }
 
+const finishEmailLoginByLink = `-- name: FinishEmailLoginByLink :one
+delete from email_logins
+where link_token = $1
+and created_at > now() - interval '10 minutes'
+returning kthid
+`
+
+func (q *Queries) FinishEmailLoginByLink(ctx context.Context, linkToken string) (string, error) {
+	row := q.db.QueryRow(ctx, finishEmailLoginByLink, linkToken)
+	var kthid string
+	err := row.Scan(&kthid)
+	return kthid, err
+}
+
 const getAllActiveMemberYears = `-- name: GetAllActiveMemberYears :many
 select distinct year_tag
 from users
@@ -498,6 +658,27 @@ func (q *Queries) GetAllYears(ctx context.Context) ([]string, error) {
 	return items, nil
 }
 
+const getEmailLoginByLink = `-- name: GetEmailLoginByLink :one
+select kthid, code, created_at, attempts, link_token, browser
+from email_logins
+where link_token = $1
+and created_at > now() - interval '10 minutes'
+`
+
+func (q *Queries) GetEmailLoginByLink(ctx context.Context, linkToken string) (EmailLogin, error) {
+	row := q.db.QueryRow(ctx, getEmailLoginByLink, linkToken)
+	var i EmailLogin
+	err := row.Scan(
+		&i.Kthid,
+		&i.Code,
+		&i.CreatedAt,
+		&i.Attempts,
+		&i.LinkToken,
+		&i.Browser,
+	)
+	return i, err
+}
+
 const getKTHIDByWebauthnID = `-- name: GetKTHIDByWebauthnID :one
 select kthid
 from users
@@ -546,23 +727,67 @@ const getSession = `-- name: GetSession :one
 update sessions
 set last_used_at = now()
 where id = $1
-and last_used_at > now() - interval '8 hours'
-returning kthid, guest_data, permissions
+and last_used_at > now() - make_interval(secs => case when remember then $2::int else $3::int end)
+and created_at > now() - make_interval(secs => case when remember then $4::int else $5::int end)
+returning kthid, guest_data, permissions, permissions_refreshed_at < now() - make_interval(secs => $6::int) as permissions_stale, impersonator
 `
 
+type GetSessionParams struct {
+	ID                    uuid.UUID
+	RememberedIdleTimeout int32
+	IdleTimeout           int32
+	RememberedMaxAge      int32
+	MaxAge                int32
+	PermissionsMaxAge     int32
+}
+
 type GetSessionRow struct {
-	Kthid       pgtype.Text
-	GuestData   []byte
-	Permissions []byte
+	Kthid            pgtype.Text
+	GuestData        []byte
+	Permissions      []byte
+	PermissionsStale bool
+	Impersonator     pgtype.Text
 }
 
-func (q *Queries) GetSession(ctx context.Context, id uuid.UUID) (GetSessionRow, error) {
-	row := q.db.QueryRow(ctx, getSession, id)
+func (q *Queries) GetSession(ctx context.Context, arg GetSessionParams) (GetSessionRow, error) {
+	row := q.db.QueryRow(ctx, getSession,
+		arg.ID,
+		arg.RememberedIdleTimeout,
+		arg.IdleTimeout,
+		arg.RememberedMaxAge,
+		arg.MaxAge,
+		arg.PermissionsMaxAge,
+	)
 	var i GetSessionRow
-	err := row.Scan(&i.Kthid, &i.GuestData, &i.Permissions)
+	err := row.Scan(
+		&i.Kthid,
+		&i.GuestData,
+		&i.Permissions,
+		&i.PermissionsStale,
+		&i.Impersonator,
+	)
 	return i, err
 }
 
+const getSessionSecondsLeft = `-- name: GetSessionSecondsLeft :one
+select extract(epoch from created_at + make_interval(secs => case when remember then $1::int else $2::int end) - now())::int as seconds_left
+from sessions
+where id = $3
+`
+
+type GetSessionSecondsLeftParams struct {
+	RememberedMaxAge int32
+	MaxAge           int32
+	ID               uuid.UUID
+}
+
+func (q *Queries) GetSessionSecondsLeft(ctx context.Context, arg GetSessionSecondsLeftParams) (int32, error) {
+	row := q.db.QueryRow(ctx, getSessionSecondsLeft, arg.RememberedMaxAge, arg.MaxAge, arg.ID)
+	var seconds_left int32
+	err := row.Scan(&seconds_left)
+	return seconds_left, err
+}
+
 const getUser = `-- name: GetUser :one
 select kthid, ug_kthid, email, first_name, family_name, year_tag, member_to, webauthn_id, first_name_change_request, family_name_change_request
 from users
@@ -662,6 +887,69 @@ func (q *Queries) ListAccountRequests(ctx context.Context) ([]AccountRequest, er
 	return items, nil
 }
 
+const listSessionsForUser = `-- name: ListSessionsForUser :many
+select id, created_at, last_used_at, login_method, user_agent, ip_address, remember, impersonator
+from sessions
+where kthid = $1
+and last_used_at > now() - make_interval(secs => case when remember then $2::int else $3::int end)
+and created_at > now() - make_interval(secs => case when remember then $4::int else $5::int end)
+order by last_used_at desc
+`
+
+type ListSessionsForUserParams struct {
+	Kthid                 pgtype.Text
+	RememberedIdleTimeout int32
+	IdleTimeout           int32
+	RememberedMaxAge      int32
+	MaxAge                int32
+}
+
+type ListSessionsForUserRow struct {
+	ID           uuid.UUID
+	CreatedAt    pgtype.Timestamp
+	LastUsedAt   pgtype.Timestamp
+	LoginMethod  string
+	UserAgent    string
+	IpAddress    string
+	Remember     bool
+	Impersonator pgtype.Text
+}
+
+func (q *Queries) ListSessionsForUser(ctx context.Context, arg ListSessionsForUserParams) ([]ListSessionsForUserRow, error) {
+	rows, err := q.db.Query(ctx, listSessionsForUser,
+		arg.Kthid,
+		arg.RememberedIdleTimeout,
+		arg.IdleTimeout,
+		arg.RememberedMaxAge,
+		arg.MaxAge,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSessionsForUserRow
+	for rows.Next() {
+		var i ListSessionsForUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.LoginMethod,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.Remember,
+			&i.Impersonator,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listUsers = `-- name: ListUsers :many
 select kthid, ug_kthid, email, first_name, family_name, year_tag, member_to, webauthn_id, first_name_change_request, family_name_change_request
 from users
@@ -791,6 +1079,52 @@ func (q *Queries) RemoveSession(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const removeSessionForUser = `-- name: RemoveSessionForUser :execrows
+delete from sessions
+where id = $1 and kthid = $2
+`
+
+type RemoveSessionForUserParams struct {
+	ID    uuid.UUID
+	Kthid pgtype.Text
+}
+
+func (q *Queries) RemoveSessionForUser(ctx context.Context, arg RemoveSessionForUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeSessionForUser, arg.ID, arg.Kthid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const removeSessionsForUser = `-- name: RemoveSessionsForUser :exec
+delete from sessions
+where kthid = $1
+`
+
+func (q *Queries) RemoveSessionsForUser(ctx context.Context, kthid pgtype.Text) error {
+	_, err := q.db.Exec(ctx, removeSessionsForUser, kthid)
+	return err
+}
+
+const setSessionPermissionsForUser = `-- name: SetSessionPermissionsForUser :exec
+update sessions
+set permissions = $2,
+    permissions_refreshed_at = now()
+where kthid = $1
+and impersonator is null
+`
+
+type SetSessionPermissionsForUserParams struct {
+	Kthid       pgtype.Text
+	Permissions []byte
+}
+
+func (q *Queries) SetSessionPermissionsForUser(ctx context.Context, arg SetSessionPermissionsForUserParams) error {
+	_, err := q.db.Exec(ctx, setSessionPermissionsForUser, arg.Kthid, arg.Permissions)
+	return err
+}
+
 const userSetEmail = `-- name: UserSetEmail :one
 update users
 set email = $2
@@ -20,9 +20,8 @@ import (
 	"github.com/a-h/templ"
 	"github.com/datasektionen/sso/database"
 	"github.com/datasektionen/sso/models"
-	"github.com/datasektionen/sso/pkg/email"
+	"github.com/datasektionen/sso/pkg/hive"
 	"github.com/datasektionen/sso/pkg/httputil"
-	"github.com/datasektionen/sso/pkg/kthldap"
 	"github.com/datasektionen/sso/service"
 	"github.com/datasektionen/sso/templates"
 	"github.com/google/uuid"
@@ -137,6 +136,14 @@ func updateAdminUser(s *service.Service, w http.ResponseWriter, r *http.Request)
 		return templates.EditMember(user, errors)
 	}
 
+	before, err := s.GetUser(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return httputil.BadRequest("No such user")
+	}
+
 	updatedUser, err := s.DB.AdminUpdateUser(r.Context(), database.AdminUpdateUserParams{
 		Kthid:      kthid,
 		Email:      email,
@@ -152,7 +159,88 @@ func updateAdminUser(s *service.Service, w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	return templates.Member(service.DBUserToModel(updatedUser))
+	after := service.DBUserToModel(updatedUser)
+	s.Audit(r, "user.update", kthid, before, after)
+
+	return templates.Member(after)
+}
+
+func refreshUserPermissions(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid := r.PathValue("kthid")
+	user, err := s.GetUser(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return httputil.BadRequest("No such user")
+	}
+	if _, err := s.RefreshSessionPermissions(r.Context(), kthid); err != nil {
+		return err
+	}
+	s.Audit(r, "user.refresh-permissions", kthid, nil, nil)
+	return templates.PermissionsRefreshed()
+}
+
+// errImpersonating is returned by handlers that change how a user logs in, which admins shouldn't do
+// on someone else's behalf.
+var errImpersonating = httputil.Forbidden("Not allowed while impersonating someone")
+
+func impersonateUser(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user, err := s.GetUser(r.Context(), r.PathValue("kthid"))
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return httputil.BadRequest("No such user")
+	}
+	return s.Impersonate(r, user.KTHID)
+}
+
+func stopImpersonating(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	if s.GetImpersonator(r) == "" {
+		return httputil.BadRequest("Not impersonating anyone")
+	}
+	return s.StopImpersonating(w, r)
+}
+
+func adminUserSessions(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user, err := s.GetUser(r.Context(), r.PathValue("kthid"))
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return httputil.BadRequest("No such user")
+	}
+	sessions, err := s.ListSessions(r.Context(), user.KTHID, s.CurrentSessionID(r))
+	if err != nil {
+		return err
+	}
+	perms := r.Context().Value(hive.PermissionsCtxKey{}).(hive.Permissions)
+	return templates.AdminUserSessions(*user, sessions, perms.ManageMembers)
+}
+
+func adminRemoveUserSession(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid := r.PathValue("kthid")
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return httputil.BadRequest("Invalid session id")
+	}
+	if ok, err := s.RemoveUserSession(r.Context(), kthid, id); err != nil {
+		return err
+	} else if !ok {
+		return httputil.BadRequest("No such session")
+	}
+	s.Audit(r, "session.remove", kthid, map[string]any{"session": id}, nil)
+	return ""
+}
+
+func adminRemoveUserSessions(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid := r.PathValue("kthid")
+	if err := s.RemoveUserSessions(r.Context(), kthid); err != nil {
+		return err
+	}
+	s.Audit(r, "session.remove-all", kthid, nil, nil)
+	return ""
 }
 
 func invites(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
@@ -194,6 +282,7 @@ func createInvite(s *service.Service, w http.ResponseWriter, r *http.Request) ht
 	if err != nil {
 		return err
 	}
+	s.Audit(r, "invite.create", inv.ID.String(), nil, auditInvite(inv))
 	return templates.Invite(inv)
 }
 
@@ -202,11 +291,18 @@ func deleteInvite(s *service.Service, w http.ResponseWriter, r *http.Request) ht
 	if err != nil {
 		return httputil.BadRequest("Invalid id")
 	}
+	inv, err := s.DB.GetInvite(r.Context(), id)
+	if err == pgx.ErrNoRows {
+		return httputil.BadRequest("No such invite")
+	} else if err != nil {
+		return err
+	}
 	if err := s.DB.DeleteInvite(r.Context(), id); err == pgx.ErrNoRows {
 		return httputil.BadRequest("No such invite")
 	} else if err != nil {
 		return err
 	}
+	s.Audit(r, "invite.delete", id.String(), auditInvite(inv), nil)
 	return nil
 }
 
@@ -238,6 +334,12 @@ func updateInvite(s *service.Service, w http.ResponseWriter, r *http.Request) ht
 	if err != nil && maxUsesStr != "" {
 		return httputil.BadRequest("Invalid int for max uses")
 	}
+	before, err := s.DB.GetInvite(r.Context(), id)
+	if err == pgx.ErrNoRows {
+		return httputil.BadRequest("No such invite")
+	} else if err != nil {
+		return err
+	}
 	inv, err := s.DB.UpdateInvite(r.Context(), database.UpdateInviteParams{
 		ID:        id,
 		Name:      name,
@@ -247,9 +349,19 @@ func updateInvite(s *service.Service, w http.ResponseWriter, r *http.Request) ht
 	if err != nil {
 		return err
 	}
+	s.Audit(r, "invite.update", id.String(), auditInvite(before), auditInvite(inv))
 	return templates.Invite(inv)
 }
 
+// auditInvite picks out what's interesting about an invite for the audit log.
+func auditInvite(inv database.Invite) map[string]any {
+	return map[string]any{
+		"name":       inv.Name,
+		"expires_at": inv.ExpiresAt,
+		"max_uses":   inv.MaxUses,
+	}
+}
+
 var memberSheet struct {
 	// This is locked when retrieving or assigning the reader channel.
 	mu sync.Mutex
@@ -357,6 +469,11 @@ func uploadSheet(s *service.Service, w http.ResponseWriter, r *http.Request) htt
 			return
 		}
 
+		var updated, failed int
+		defer func() {
+			s.Audit(r, "members.upload-sheet", "", nil, map[string]any{"updated": updated, "failed": failed})
+		}()
+
 		for i, columns := range rows[1:] {
 			if len(columns) == 0 {
 				continue
@@ -412,7 +529,7 @@ func uploadSheet(s *service.Service, w http.ResponseWriter, r *http.Request) htt
 			if err := s.DB.Tx(ctx, func(db *database.Queries) error {
 				_, err := db.GetUser(ctx, kthid)
 				if err == pgx.ErrNoRows {
-					person, err := kthldap.Lookup(ctx, kthid)
+					person, err := s.LDAP.Lookup(ctx, kthid)
 					if err != nil {
 						return err
 					}
@@ -449,6 +566,9 @@ func uploadSheet(s *service.Service, w http.ResponseWriter, r *http.Request) htt
 					kthid,
 					err,
 				), true)}
+				failed++
+			} else {
+				updated++
 			}
 			events <- sheetEvent{"progress", templates.UploadProgress(float64(i) / float64(len(rows)-1))}
 		}
@@ -529,6 +649,7 @@ func createOIDCClient(s *service.Service, w http.ResponseWriter, r *http.Request
 		}
 		return err
 	}
+	s.Audit(r, "oidc-client.create", id, nil, auditOIDCClient(client))
 	return templates.OidcClient(client, secret[:])
 }
 
@@ -541,6 +662,7 @@ func updateOIDCClient(s *service.Service, w http.ResponseWriter, r *http.Request
 	} else if err != nil {
 		return err
 	}
+	before := client
 
 	if hiveSystemID := r.FormValue("hive-system-id"); hiveSystemID != "" {
 		client, err = s.DB.UpdateClientHiveSystemID(r.Context(), database.UpdateClientHiveSystemIDParams{
@@ -563,17 +685,47 @@ func updateOIDCClient(s *service.Service, w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	if membersOnlyVal := r.FormValue("members-only"); membersOnlyVal != "" {
+		membersOnly := membersOnlyVal == "true"
+		client, err = s.DB.UpdateClientMembersOnly(r.Context(), database.UpdateClientMembersOnlyParams{
+			ID:          id,
+			MembersOnly: membersOnly,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.Audit(r, "oidc-client.update", id, auditOIDCClient(before), auditOIDCClient(client))
 	return templates.OidcClient(client, nil)
 }
 
+// auditOIDCClient picks out what's interesting about an OIDC client for the audit log. Notably
+// this does not include the secret hash.
+func auditOIDCClient(client database.OidcClient) map[string]any {
+	return map[string]any{
+		"redirect_uris":  client.RedirectUris,
+		"hive_system_id": client.HiveSystemID,
+		"allow_guests":   client.AllowGuests,
+		"members_only":   client.MembersOnly,
+	}
+}
+
 func deleteOIDCClient(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
 	id := r.PathValue("id")
 
+	client, err := s.DB.GetClient(r.Context(), id)
+	if err == pgx.ErrNoRows {
+		return httputil.BadRequest("No such client")
+	} else if err != nil {
+		return err
+	}
 	if err := s.DB.DeleteClient(r.Context(), id); err == pgx.ErrNoRows {
 		return httputil.BadRequest("No such client")
 	} else if err != nil {
 		return err
 	}
+	s.Audit(r, "oidc-client.delete", id, auditOIDCClient(client), nil)
 	return nil
 }
 
@@ -602,6 +754,7 @@ func addRedirectURI(s *service.Service, w http.ResponseWriter, r *http.Request)
 	); err != nil {
 		return err
 	}
+	s.Audit(r, "oidc-client.add-redirect-uri", id, nil, map[string]any{"redirect_uri": newURI})
 
 	return templates.RedirectURI(id, newURI)
 }
@@ -628,6 +781,7 @@ func removeRedirectURI(s *service.Service, w http.ResponseWriter, r *http.Reques
 	); err != nil {
 		return err
 	}
+	s.Audit(r, "oidc-client.remove-redirect-uri", id, map[string]any{"redirect_uri": uri}, nil)
 
 	return nil
 }
@@ -655,6 +809,7 @@ func denyAccountRequest(s *service.Service, w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		return err
 	}
+	s.Audit(r, "account-request.deny", req.Kthid, auditAccountRequest(req), nil)
 
 	if r.URL.Query().Has("send-email") {
 		var recipient string
@@ -668,12 +823,12 @@ func denyAccountRequest(s *service.Service, w http.ResponseWriter, r *http.Reque
 			return "Denied ❌ (no email address)"
 		}
 
-		if err := email.Send(
+		if err := s.Email.Send(
 			r.Context(),
 			recipient,
 			"Datasektionen account request denied", "<p>Your Datasektionen account request has been denied.</p>",
 		); err != nil {
-			slog.Error("Could not send email", "recipient", recipient, "error", err)
+			slog.ErrorContext(r.Context(), "Could not send email", "recipient", recipient, "error", err)
 			return "Denied, but could not send email!"
 		}
 		return "Denied ❌ and sent email!"
@@ -724,19 +879,33 @@ func approveAccountRequest(s *service.Service, w http.ResponseWriter, r *http.Re
 	if err := tx.Commit(r.Context()); err != nil {
 		return err
 	}
+	s.Audit(r, "account-request.approve", accountRequest.Kthid, auditAccountRequest(accountRequest), nil)
 
-	if err := email.Send(r.Context(), accountRequest.Email, "Datasektionen account request approved", strings.TrimSpace(fmt.Sprintf(`
+	if err := s.Email.Send(r.Context(), accountRequest.Email, "Datasektionen account request approved", strings.TrimSpace(fmt.Sprintf(`
 Hello %s, your Datasektionen account request has been approved!
 
 You can go to [sso.datasektionen.se](https://sso.datasektionen.se/) to log in and see your account, or simply go directly to a system you want to log in to.
 	`, accountRequest.FirstName))); err != nil {
-		slog.Error("Could not send email", "error", err)
+		slog.ErrorContext(r.Context(), "Could not send email", "error", err)
 		return "Approved, but could not send email!"
 	}
 
 	return "Approved ✅"
 }
 
+// auditAccountRequest picks out what's interesting about an account request for the audit log.
+func auditAccountRequest(req database.AccountRequest) map[string]any {
+	return map[string]any{
+		"kthid":       req.Kthid,
+		"email":       req.Email,
+		"first_name":  req.FirstName,
+		"family_name": req.FamilyName,
+		"year_tag":    req.YearTag,
+		"reference":   req.Reference,
+		"reason":      req.Reason,
+	}
+}
+
 func nameChangeRequests(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
 	users, err := s.DB.ListUsersWithNameChangeRequests(r.Context())
 	if err != nil {
@@ -747,16 +916,39 @@ func nameChangeRequests(s *service.Service, w http.ResponseWriter, r *http.Reque
 
 func approveNameChangeRequest(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
 	kthid := r.PathValue("kthid")
+	before, err := s.GetUser(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return httputil.BadRequest("No such user")
+	}
 	if err := s.DB.ApproveNameChangeRequest(r.Context(), kthid); err != nil {
 		return err
 	}
+	after, err := s.GetUser(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	s.Audit(r, "name-change.approve", kthid, before, after)
 	return "Approved ✅"
 }
 
 func denyNameChangeRequest(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
 	kthid := r.PathValue("kthid")
+	before, err := s.GetUser(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return httputil.BadRequest("No such user")
+	}
 	if err := s.DB.DenyNameChangeRequest(r.Context(), kthid); err != nil {
 		return err
 	}
+	s.Audit(r, "name-change.deny", kthid, map[string]any{
+		"first_name_change_request":  before.FirstNameChangeRequest,
+		"family_name_change_request": before.FamilyNameChangeRequest,
+	}, nil)
 	return "Denied ❌"
 }
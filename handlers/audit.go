@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/service"
+	"github.com/datasektionen/sso/templates"
+)
+
+const auditPageSize = 50
+
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Actor:  strings.TrimSpace(r.FormValue("actor")),
+		Action: r.FormValue("action"),
+		Target: strings.TrimSpace(r.FormValue("target")),
+	}
+	for _, date := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := r.FormValue(date.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return filter, httputil.BadRequest("Invalid date for " + date.name)
+		}
+		*date.dst = t
+	}
+	return filter, nil
+}
+
+func auditLog(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		return err
+	}
+	offsetStr := r.FormValue("offset")
+	offset, err := strconv.Atoi(offsetStr)
+	if (err != nil && offsetStr != "") || offset < 0 {
+		return httputil.BadRequest("Invalid int for offset")
+	}
+	entries, err := s.ListAuditLog(r.Context(), filter, auditPageSize+1, offset)
+	if err != nil {
+		return err
+	}
+	more := false
+	if len(entries) > auditPageSize {
+		entries = entries[:auditPageSize]
+		more = true
+	}
+	actions, err := s.DB.ListAuditActions(r.Context())
+	if err != nil {
+		return err
+	}
+	return templates.AuditLog(entries, filter, actions, offset, auditPageSize, more)
+}
+
+func exportAuditLog(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		return err
+	}
+	// The log can be large, so it's streamed a batch at a time. The first batch is fetched up
+	// front so that a database error can still be responded with properly.
+	const batchSize = 1000
+	batch, err := s.ListAuditLogBefore(r.Context(), filter, batchSize, nil)
+	if err != nil {
+		return err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-log.csv"`)
+		out := csv.NewWriter(w)
+		_ = out.Write([]string{"time", "actor", "action", "target", "before", "after", "ip_address", "impersonated"})
+		for len(batch) > 0 {
+			for _, entry := range batch {
+				_ = out.Write([]string{
+					entry.CreatedAt.Format(time.RFC3339),
+					csvCell(entry.Actor),
+					csvCell(entry.Action),
+					csvCell(entry.Target),
+					csvCell(string(entry.Before)),
+					csvCell(string(entry.After)),
+					csvCell(entry.IPAddress),
+					csvCell(entry.Impersonated),
+				})
+			}
+			out.Flush()
+			if len(batch) < batchSize {
+				break
+			}
+			batch, err = s.ListAuditLogBefore(r.Context(), filter, batchSize, &batch[len(batch)-1])
+			if err != nil {
+				// Too late to change the status code, so the export just ends early.
+				slog.ErrorContext(r.Context(), "Could not export audit log", "error", err)
+				return
+			}
+		}
+	})
+}
+
+// csvCell keeps spreadsheet programs from running values as formulas, since e.g. targets and
+// names can be chosen by anyone.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
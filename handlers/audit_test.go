@@ -0,0 +1,24 @@
+package handlers
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                       "",
+		"turetek":                "turetek",
+		"session.create":         "session.create",
+		"=HYPERLINK(\"x\")":      "'=HYPERLINK(\"x\")",
+		"+46701234567":           "'+46701234567",
+		"-1":                     "'-1",
+		"@SUM(A1:A2)":            "'@SUM(A1:A2)",
+		"\t=1":                   "'\t=1",
+		"\r=1":                   "'\r=1",
+		"a=1":                    "a=1",
+		"2025-01-01T00:00:00Z":   "2025-01-01T00:00:00Z",
+		"{\"first_name\":\"=\"}": "{\"first_name\":\"=\"}",
+	} {
+		if got := csvCell(value); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/datasektionen/sso/models"
 	"github.com/datasektionen/sso/pkg/httputil"
 	"github.com/datasektionen/sso/service"
 )
@@ -17,7 +18,7 @@ func devLogin(s *service.Service, w http.ResponseWriter, r *http.Request) httput
 	if user == nil {
 		return httputil.BadRequest("No such user")
 	}
-	return s.LoginUser(r.Context(), user.KTHID, true)
+	return s.LoginUser(r, user.KTHID, models.LoginMethodDev, true)
 }
 
 func autoReload(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image/png"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/service"
+	"github.com/datasektionen/sso/templates"
+)
+
+// deviceLoginCookie holds the id of the device login, so that only the browser that started it can
+// be logged in by it. The code shown in the QR code is not enough.
+const deviceLoginCookie = "_sso_device-login"
+
+// Without characters that are easily confused with each other.
+const deviceLoginAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func randomDeviceLoginCode() string {
+	var code strings.Builder
+	max := big.NewInt(int64(len(deviceLoginAlphabet)))
+	for i := range 8 {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		if i == 4 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(deviceLoginAlphabet[idx.Int64()])
+	}
+	return code.String()
+}
+
+// normalizeDeviceLoginCode accepts codes typed in lower case or without the dash.
+func normalizeDeviceLoginCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+func deviceLoginApproveURL(s *service.Service, code string) string {
+	u := s.Cfg.Origin.JoinPath("/login/device")
+	if code != "" {
+		u.RawQuery = url.Values{"code": {code}}.Encode()
+	}
+	return u.String()
+}
+
+// currentDeviceLogin returns the device login started by this browser, or nil if there is none or
+// it has expired.
+func currentDeviceLogin(s *service.Service, r *http.Request) (*database.DeviceLogin, error) {
+	c, _ := r.Cookie(deviceLoginCookie)
+	if c == nil {
+		return nil, nil
+	}
+	id, err := uuid.Parse(c.Value)
+	if err != nil {
+		return nil, nil
+	}
+	login, err := s.DB.GetDeviceLogin(r.Context(), id)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func beginDeviceLogin(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	code := randomDeviceLoginCode()
+	id, err := s.DB.CreateDeviceLogin(r.Context(), database.CreateDeviceLoginParams{
+		Code:      code,
+		UserAgent: r.UserAgent(),
+		IpAddress: httputil.ClientIP(r),
+	})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceLoginCookie,
+		Value:    id.String(),
+		Path:     "/login/device",
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		Secure:   !s.Cfg.Dev,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return templates.DeviceLoginWaiting(code, deviceLoginApproveURL(s, ""))
+}
+
+func deviceLoginQRCode(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	login, err := currentDeviceLogin(s, r)
+	if err != nil {
+		return err
+	}
+	if login == nil {
+		return httputil.NotFound()
+	}
+	code, err := qr.Encode(deviceLoginApproveURL(s, login.Code), qr.M, qr.Auto)
+	if err != nil {
+		return err
+	}
+	code, err = barcode.Scale(code, 200, 200)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
+func pollDeviceLogin(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	login, err := currentDeviceLogin(s, r)
+	if err != nil {
+		return err
+	}
+	if login == nil {
+		return templates.DeviceLoginExpired()
+	}
+	if !login.ApprovedBy.Valid {
+		// htmx doesn't swap anything on 204, so it just keeps polling.
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	kthid, err := s.DB.TakeApprovedDeviceLogin(r.Context(), login.ID)
+	if err == pgx.ErrNoRows {
+		// Some other request got here first.
+		return templates.DeviceLoginExpired()
+	}
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{Name: deviceLoginCookie, Path: "/login/device", MaxAge: -1})
+	return s.LoginUser(r, kthid.String, models.LoginMethodDevice, true)
+}
+
+func deviceLoginPage(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	if s.GetLoggedInGuestUser(r) != nil {
+		return templates.MissingAccount()
+	}
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Redirect("/?" + url.Values{"next-url": {r.URL.RequestURI()}}.Encode())
+	}
+	code := normalizeDeviceLoginCode(r.FormValue("code"))
+	if code == "" {
+		return templates.DeviceLoginPage(*user, "", nil)
+	}
+	login, err := s.DB.GetDeviceLoginByCode(r.Context(), code)
+	if err == pgx.ErrNoRows {
+		return templates.DeviceLoginPage(*user, code, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return templates.DeviceLoginPage(*user, code, &login)
+}
+
+func approveDeviceLogin(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	code := r.FormValue("code")
+	login, err := s.DB.GetDeviceLoginByCode(r.Context(), code)
+	if err == pgx.ErrNoRows {
+		return httputil.BadRequest("The code doesn't exist or has expired")
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := s.DB.ApproveDeviceLogin(r.Context(), database.ApproveDeviceLoginParams{
+		Code:       code,
+		ApprovedBy: pgtype.Text{String: user.KTHID, Valid: true},
+	}); err != nil {
+		return err
+	} else if n == 0 {
+		return httputil.BadRequest("The code doesn't exist or has expired")
+	}
+	s.Audit(r, "device-login.approve", user.KTHID, nil, map[string]any{
+		"user_agent": login.UserAgent,
+		"ip_address": login.IpAddress,
+	})
+	return templates.DeviceLoginDone(true)
+}
+
+func denyDeviceLogin(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	if s.GetLoggedInUser(r) == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	if _, err := s.DB.DenyDeviceLogin(r.Context(), r.FormValue("code")); err != nil {
+		return err
+	}
+	return templates.DeviceLoginDone(false)
+}
@@ -0,0 +1,1055 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/metadata"
+	"github.com/go-webauthn/webauthn/metadata/providers/memory"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pquerna/otp/totp"
+	"github.com/zitadel/oidc/v3/pkg/client/rp"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/auth"
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/fakehive"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/pkg/jobs"
+	"github.com/datasektionen/sso/pkg/kthldap"
+	"github.com/datasektionen/sso/pkg/logging"
+	"github.com/datasektionen/sso/pkg/testutil"
+)
+
+func createUser(t *testing.T, app *testutil.App, kthid string) {
+	t.Helper()
+	if err := app.Service.DB.CreateUser(context.Background(), database.CreateUserParams{
+		Kthid:      kthid,
+		UgKthid:    "u1" + kthid,
+		Email:      kthid + "@kth.se",
+		FirstName:  "Test",
+		FamilyName: "Testsson",
+		MemberTo:   pgtype.Date{Time: time.Now().AddDate(1, 0, 0), Valid: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// beginEmailLogin requests a login code and returns it.
+func beginEmailLogin(t *testing.T, app *testutil.App, b *testutil.Browser, kthid string) string {
+	t.Helper()
+	resp, body := b.PostForm("/login/email/begin", url.Values{"kthid": {kthid}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("begin email login: %d %s", resp.StatusCode, body)
+	}
+	email, ok := app.Upstreams.Spam.LastEmailTo(kthid + "@kth.se")
+	if !ok {
+		t.Fatal("No login code was sent")
+	}
+	_, code, ok := strings.Cut(email.Content, "`")
+	code, _, ok2 := strings.Cut(code, "`")
+	if !ok || !ok2 {
+		t.Fatalf("Could not find code in email: %q", email.Content)
+	}
+	return code
+}
+
+// emailLogin logs in and returns the response at the end of the redirect
+// chain.
+func emailLogin(t *testing.T, app *testutil.App, b *testutil.Browser, kthid string) (*http.Response, string) {
+	t.Helper()
+	code := beginEmailLogin(t, app, b, kthid)
+	return b.PostForm("/login/email/finish", url.Values{"kthid": {kthid}, "code": {code}})
+}
+
+func expectAccountPage(t *testing.T, resp *http.Response, body string) {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/account" {
+		t.Fatalf("Expected to end up at /account, got %d at %s: %s", resp.StatusCode, resp.Request.URL, body)
+	}
+}
+
+// dataAttribute returns the JSON stored in a data attribute in the HTML.
+func dataAttribute(t *testing.T, body, name string) string {
+	t.Helper()
+	m := regexp.MustCompile(`data-` + name + `="([^"]*)"`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("No data-%s attribute in %s", name, body)
+	}
+	return html.UnescapeString(m[1])
+}
+
+func TestEmailLogin(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+
+	code := beginEmailLogin(t, app, b, "turetek")
+	words := strings.Fields(code)
+	slices.Reverse(words)
+	wrong := strings.Join(words, " ")
+
+	for range 3 {
+		resp, body := b.PostForm("/login/email/finish", url.Values{"kthid": {"turetek"}, "code": {wrong}})
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "Invalid code") {
+			t.Fatalf("Expected invalid code, got %d %s", resp.StatusCode, body)
+		}
+	}
+	resp, body := b.PostForm("/login/email/finish", url.Values{"kthid": {"turetek"}, "code": {code}})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "Too many invalid attempts") {
+		t.Fatalf("Expected exhausted code, got %d %s", resp.StatusCode, body)
+	}
+	resp, body = b.PostForm("/login/email/finish", url.Values{"kthid": {"turetek"}, "code": {code}})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "does not have a login code") {
+		t.Fatalf("Expected missing code, got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = emailLogin(t, app, b, "turetek")
+	expectAccountPage(t, resp, body)
+	if !strings.Contains(body, "Testsson") {
+		t.Fatalf("Account page doesn't show the user's name: %s", body)
+	}
+
+	b.Get("/logout")
+	if _, body := b.Get("/account"); strings.Contains(body, "Testsson") {
+		t.Fatalf("Still logged in after logging out")
+	}
+}
+
+// addPasskey registers a passkey with the browser's authenticator.
+func addPasskey(t *testing.T, b *testutil.Browser, name string) {
+	t.Helper()
+	resp, body := postPasskey(t, b, name)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, name) {
+		t.Fatalf("Could not add passkey: %d %s", resp.StatusCode, body)
+	}
+}
+
+// postPasskey creates a passkey with the browser's authenticator and returns the response from
+// adding it.
+func postPasskey(t *testing.T, b *testutil.Browser, name string) (*http.Response, string) {
+	t.Helper()
+	_, body := b.Get("/passkey/add-form")
+	var cc protocol.CredentialCreation
+	if err := json.Unmarshal([]byte(dataAttribute(t, body, "credential-creation")), &cc); err != nil {
+		t.Fatal(err)
+	}
+	sessionID := dataAttribute(t, body, "session-id")
+	cred, err := b.Authenticator.Create(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqBody, err := json.Marshal(struct {
+		Name      string          `json:"name"`
+		SessionID json.RawMessage `json:"session"`
+		protocol.CredentialCreationResponse
+	}{name, json.RawMessage(sessionID), cred})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.PostJSON("/passkey", string(reqBody))
+}
+
+func TestEmailLoginLink(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	beginEmailLogin(t, app, b, "turetek")
+	email, _ := app.Upstreams.Spam.LastEmailTo("turetek@kth.se")
+	match := regexp.MustCompile(`\]\(([^)]*/login/email/link\?token=[^)]*)\)`).FindStringSubmatch(email.Content)
+	if match == nil {
+		t.Fatalf("No login link in email: %q", email.Content)
+	}
+	link, err := url.Parse(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opened somewhere else, e.g. by a link scanner, the link must not be used up.
+	other := app.NewBrowser(t)
+	if resp, body := other.Get(link.RequestURI()); resp.StatusCode != http.StatusOK || !strings.Contains(body, "Log in as turetek?") {
+		t.Fatalf("Expected a confirmation, got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body := b.Get(link.RequestURI())
+	expectAccountPage(t, resp, body)
+
+	if resp, body := other.PostForm("/login/email/link", url.Values{"token": {link.Query().Get("token")}}); !strings.Contains(body, "already been used") {
+		t.Fatalf("Expected the link to be used up, got %d %s", resp.StatusCode, body)
+	}
+}
+
+// passkeyLogin logs in without giving a kthid and returns the response from
+// /login/passkey/finish.
+func passkeyLogin(t *testing.T, b *testutil.Browser) (*http.Response, string) {
+	t.Helper()
+	_, body := b.PostForm("/login/passkey/begin", url.Values{"kthid": {""}})
+	var ca protocol.CredentialAssertion
+	if err := json.Unmarshal([]byte(dataAttribute(t, body, "cred-ass")), &ca); err != nil {
+		t.Fatal(err)
+	}
+	sessionID := dataAttribute(t, body, "session-id")
+	assertion, err := b.Authenticator.Get(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqBody, err := json.Marshal(struct {
+		SessionID json.RawMessage                      `json:"session"`
+		Cred      protocol.CredentialAssertionResponse `json:"cred"`
+	}{json.RawMessage(sessionID), assertion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.PostJSON("/login/passkey/finish", string(reqBody))
+}
+
+func TestPasskey(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	resp, body := emailLogin(t, app, b, "turetek")
+	expectAccountPage(t, resp, body)
+	addPasskey(t, b, "laptop")
+
+	// Log in using the same authenticator in a fresh browser.
+	b2 := app.NewBrowser(t)
+	b2.Authenticator = b.Authenticator
+	resp, body = passkeyLogin(t, b2)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not log in with passkey: %d %s", resp.StatusCode, body)
+	}
+	resp, body = b2.Get("/account")
+	expectAccountPage(t, resp, body)
+	if !strings.Contains(body, "last used") {
+		t.Fatalf("Expected the passkey to be marked as used: %s", body)
+	}
+
+	passkeys, err := app.Service.ListPasskeysForUser(context.Background(), "turetek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Cred.Authenticator.SignCount != 1 {
+		t.Fatalf("Expected the sign count to be stored, got %+v", passkeys)
+	}
+	form := url.Values{"name": {"phone"}}
+	req, err := http.NewRequest(http.MethodPatch, app.URL.JoinPath("/passkey", passkeys[0].ID.String()).String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if resp, body := b2.Do(req); resp.StatusCode != http.StatusOK || !strings.Contains(body, "phone") {
+		t.Fatalf("Could not rename passkey: %d %s", resp.StatusCode, body)
+	}
+
+	// Log in the way the index page does when the browser supports autofill.
+	b3 := app.NewBrowser(t)
+	b3.Authenticator = b.Authenticator
+	_, body = b3.PostForm("/login/passkey/autofill", nil)
+	var autofill struct {
+		CredAss protocol.CredentialAssertion `json:"credAss"`
+		Session json.RawMessage              `json:"session"`
+	}
+	if err := json.Unmarshal([]byte(body), &autofill); err != nil {
+		t.Fatalf("Could not begin autofill login: %v %s", err, body)
+	}
+	if autofill.CredAss.Mediation != protocol.MediationConditional {
+		t.Fatalf("Expected conditional mediation, got %q", autofill.CredAss.Mediation)
+	}
+	assertion, err := b3.Authenticator.Get(autofill.CredAss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqBody, err := json.Marshal(struct {
+		SessionID json.RawMessage                      `json:"session"`
+		Cred      protocol.CredentialAssertionResponse `json:"cred"`
+	}{autofill.Session, assertion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := b3.PostJSON("/login/passkey/finish", string(reqBody)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not log in with autofill: %d %s", resp.StatusCode, body)
+	}
+	// The session data is used up.
+	if resp, _ := b3.PostJSON("/login/passkey/finish", string(reqBody)); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected reused session data to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestInvite(t *testing.T) {
+	app := testutil.NewApp(t)
+	ctx := context.Background()
+	inv, err := app.Service.DB.CreateInvite(ctx, database.CreateInviteParams{
+		Name:      "Reception",
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(time.Hour), Valid: true},
+		MaxUses:   pgtype.Int4{Int32: 1, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Upstreams.LDAP.Add(kthldap.Person{KTHID: "nollan", UGKTHID: "u1nollan", FirstName: "Nolla", FamilyName: "Nollsson"})
+	app.KTH.LogInAs("nollan", "Nolla Nollsson")
+
+	b := app.NewBrowser(t)
+	resp, body := b.Get("/invite/" + inv.ID.String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not open invite: %d %s", resp.StatusCode, body)
+	}
+	resp, body = b.Get("/oidc/kth/login")
+	expectAccountPage(t, resp, body)
+
+	user, err := app.Service.GetUser(ctx, "nollan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.FirstName != "Nolla" {
+		t.Fatalf("Expected user to be created from ldap, got %+v", user)
+	}
+	inv, err = app.Service.DB.GetInvite(ctx, inv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.CurrentUses != 1 {
+		t.Fatalf("Expected invite to have been used once, got %d", inv.CurrentUses)
+	}
+
+	// The invite is used up, so someone else should not be able to use it.
+	b2 := app.NewBrowser(t)
+	if resp, _ := b2.Get("/invite/" + inv.ID.String()); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected used up invite to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+// newRelyingParty creates an OIDC client and returns a relying party for it that makes requests
+// with the browser.
+func newRelyingParty(t *testing.T, app *testutil.App, b *testutil.Browser) rp.RelyingParty {
+	t.Helper()
+	ctx := context.Background()
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		t.Fatal(err)
+	}
+	secretHash := sha256.Sum256(secret[:])
+	const redirectURI = "https://client.example/callback"
+	if _, err := app.Service.DB.CreateClient(ctx, database.CreateClientParams{ID: "client", SecretHash: secretHash[:]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Service.DB.UpdateClientRedirectURIs(ctx, database.UpdateClientRedirectURIsParams{
+		ID:           "client",
+		RedirectUris: []string{redirectURI},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := rp.NewRelyingPartyOIDC(
+		ctx,
+		app.URL.JoinPath("/op").String(),
+		"client",
+		base64.URLEncoding.EncodeToString(secret[:]),
+		redirectURI,
+		[]string{"openid", "profile", "email"},
+		rp.WithHTTPClient(&http.Client{Transport: b.Client.Transport}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	app := testutil.NewApp(t)
+	ctx := context.Background()
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	client := newRelyingParty(t, app, b)
+
+	resp, body := b.Get(rp.AuthURL("some-state", client))
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/" {
+		t.Fatalf("Expected to be sent to the login page, got %d at %s: %s", resp.StatusCode, resp.Request.URL, body)
+	}
+	resp, body = emailLogin(t, app, b, "turetek")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect to client, got %d at %s: %s", resp.StatusCode, resp.Request.URL, body)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Host != "client.example" || location.Query().Get("state") != "some-state" {
+		t.Fatalf("Unexpected redirect to %s", location)
+	}
+
+	tokens, err := rp.CodeExchange[*oidc.IDTokenClaims](ctx, location.Query().Get("code"), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.IDTokenClaims.Email != "turetek@kth.se" || tokens.IDTokenClaims.FamilyName != "Testsson" {
+		t.Fatalf("Unexpected claims in ID token: %+v", tokens.IDTokenClaims)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	laptop := app.NewBrowser(t)
+	phone := app.NewBrowser(t)
+	emailLogin(t, app, laptop, "turetek")
+	emailLogin(t, app, phone, "turetek")
+
+	sessionURLs := regexp.MustCompile(`hx-delete="(/account/sessions/[^"]+)"`)
+	_, body := laptop.Get("/account")
+	urls := sessionURLs.FindAllStringSubmatch(body, -1)
+	if len(urls) != 2 || strings.Count(body, "(this session)") != 1 {
+		t.Fatalf("Expected two sessions, one of them current, got %s", body)
+	}
+	if !strings.Contains(body, "Email code") {
+		t.Fatalf("Login method is not shown: %s", body)
+	}
+
+	// The current session is listed first since it was used last.
+	other := urls[1][1]
+	req, err := http.NewRequest(http.MethodDelete, app.URL.JoinPath(other).String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := laptop.Do(req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not remove session: %d %s", resp.StatusCode, body)
+	}
+	if _, body := phone.Get("/account"); strings.Contains(body, "Testsson") {
+		t.Fatal("Removed session is still logged in")
+	}
+	if resp, body := laptop.Get("/account"); resp.Request.URL.Path != "/account" || !strings.Contains(body, "Testsson") {
+		t.Fatal("Removing another session logged out the current one")
+	}
+
+	emailLogin(t, app, phone, "turetek")
+	req, err = http.NewRequest(http.MethodDelete, app.URL.JoinPath("/account/sessions").String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop.Do(req)
+	for _, b := range []*testutil.Browser{laptop, phone} {
+		if _, body := b.Get("/account"); strings.Contains(body, "Testsson") {
+			t.Fatal("Still logged in after logging out everywhere")
+		}
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+
+	if _, body := b.Get("/"); !strings.Contains(body, "Remember this device") {
+		t.Fatalf("No remember me checkbox on the login page: %s", body)
+	}
+	// This is what the checkbox does.
+	b.Client.Jar.SetCookies(app.URL, []*http.Cookie{{Name: auth.RememberMeCookieName, Value: "true"}})
+
+	resp, body := emailLogin(t, app, b, "turetek")
+	expectAccountPage(t, resp, body)
+	if !strings.Contains(body, "remembered") {
+		t.Fatalf("Session is not remembered: %s", body)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	app := testutil.NewApp(t)
+	ctx := context.Background()
+
+	if err := app.Service.Jobs.RunNow(ctx, "no-such-job"); err != jobs.ErrNoSuchJob {
+		t.Fatalf("Expected ErrNoSuchJob, got %v", err)
+	}
+	for _, job := range jobs.Cleanup(app.Cfg) {
+		if err := app.Service.Jobs.RunNow(ctx, job.Name); err != nil {
+			t.Fatalf("Job %s failed: %v", job.Name, err)
+		}
+	}
+	statuses, err := app.Service.Jobs.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.LastStartedAt.IsZero() || status.LastError != "" {
+			t.Fatalf("Unexpected status after running job: %+v", status)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	createUser(t, app, "nollan")
+	app.Upstreams.Hive.Set("turetek", "sso",
+		fakehive.Permission{ID: "manage-members"},
+		fakehive.Permission{ID: "read-audit-log"},
+	)
+	admin := app.NewBrowser(t)
+	emailLogin(t, app, admin, "turetek")
+
+	form := url.Values{
+		"first-name":  {"Nolla"},
+		"family-name": {"Testsson"},
+		"email":       {"nollan@kth.se"},
+	}
+	req, err := http.NewRequest(http.MethodPut, app.URL.JoinPath("/admin/users/nollan").String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if resp, body := admin.Do(req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not update user: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body := admin.Get("/admin/audit?action=user.update")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not open audit log: %d %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "nollan") || !strings.Contains(body, "FirstName") || !strings.Contains(body, "Nolla") {
+		t.Fatalf("The update is not in the audit log: %s", body)
+	}
+	if strings.Contains(body, "FamilyName") {
+		t.Fatalf("Unchanged fields should not be logged: %s", body)
+	}
+
+	resp, body = admin.Get("/admin/audit/export.csv?actor=turetek")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("Could not export audit log: %d %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "turetek,user.update,nollan,") || !strings.Contains(body, "turetek,login,turetek,") {
+		t.Fatalf("Expected the update and the login in the export: %s", body)
+	}
+
+	if err := app.Service.DB.InsertAuditLog(context.Background(), database.InsertAuditLogParams{
+		Action: "rate-limit.exceed",
+		Target: "=1+1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, body = admin.Get("/admin/audit/export.csv?action=rate-limit.exceed")
+	if !strings.Contains(body, ",rate-limit.exceed,'=1+1,") {
+		t.Fatalf("Expected the formula to be escaped in the export: %s", body)
+	}
+
+	user := app.NewBrowser(t)
+	emailLogin(t, app, user, "nollan")
+	if resp, _ := user.Get("/admin/audit"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the audit log to require a permission, got %d", resp.StatusCode)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	app := testutil.NewApp(t)
+	ctx := context.Background()
+	createUser(t, app, "turetek")
+	createUser(t, app, "nollan")
+	app.Upstreams.Hive.Set("turetek", "sso",
+		fakehive.Permission{ID: "read-members"},
+		fakehive.Permission{ID: "impersonate-users"},
+	)
+	b := app.NewBrowser(t)
+	client := newRelyingParty(t, app, b)
+	emailLogin(t, app, b, "turetek")
+
+	resp, body := b.PostForm("/admin/users/nollan/impersonate", nil)
+	expectAccountPage(t, resp, body)
+	if !strings.Contains(body, "viewing SSO as") || !strings.Contains(body, "(nollan)") {
+		t.Fatalf("Expected an impersonation banner: %s", body)
+	}
+	// The impersonated session must not have the admin's permissions.
+	if resp, _ := b.Get("/admin/members"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected admin pages to be forbidden while impersonating, got %d", resp.StatusCode)
+	}
+	// Nor can the admin act as the user.
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		path := "/account"
+		if method == http.MethodDelete {
+			path = "/account/sessions/" + uuid.NewString()
+		}
+		req, err := http.NewRequest(method, app.URL.JoinPath(path).String(), strings.NewReader("first-name=Hacked"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if resp, _ := b.Do(req); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected %s %s to be forbidden while impersonating, got %d", method, path, resp.StatusCode)
+		}
+	}
+	// The QR code has the secret of a TOTP setup the user may have begun.
+	if resp, _ := b.Get("/account/totp/qr.png"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the TOTP QR code to be forbidden while impersonating, got %d", resp.StatusCode)
+	}
+
+	resp, body = b.Get(rp.AuthURL("some-state", client))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect to client, got %d at %s: %s", resp.StatusCode, resp.Request.URL, body)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := rp.CodeExchange[*oidc.IDTokenClaims](ctx, location.Query().Get("code"), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.IDTokenClaims.Subject != "nollan" || tokens.IDTokenClaims.Actor == nil || tokens.IDTokenClaims.Actor.Subject != "turetek" {
+		t.Fatalf("Expected an ID token for nollan acted on by turetek, got %+v", tokens.IDTokenClaims)
+	}
+
+	resp, body = b.PostForm("/impersonation/stop", nil)
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/admin/members" {
+		t.Fatalf("Expected to be back at the admin page, got %d at %s: %s", resp.StatusCode, resp.Request.URL, body)
+	}
+	if _, body := b.Get("/account"); !strings.Contains(body, "turetek") || strings.Contains(body, "viewing SSO as") {
+		t.Fatalf("Expected to be logged in as the admin again: %s", body)
+	}
+
+	entries, err := app.Service.ListAuditLog(ctx, models.AuditFilter{Actor: "turetek"}, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		if entry.Impersonated == "nollan" {
+			actions = append(actions, entry.Action)
+		}
+	}
+	for _, action := range []string{"impersonation.request", "impersonation.oidc-login", "impersonation.stop"} {
+		if !slices.Contains(actions, action) {
+			t.Fatalf("Expected %s to be audited while impersonating, got %v", action, actions)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	app := testutil.NewApp(t)
+	ctx := context.Background()
+	createUser(t, app, "turetek")
+	createUser(t, app, "nollan")
+	app.Upstreams.Hive.Set("turetek", "sso", fakehive.Permission{ID: "manage-members"})
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "nollan")
+
+	if resp, body := b.PostForm("/account/totp", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not begin setting up TOTP: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := b.Get("/account/totp/qr.png"); resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected a QR code, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	secret, err := app.Service.DB.GetTOTP(ctx, "nollan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(secret.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := b.PostForm("/account/totp/confirm", url.Values{"code": {code}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Set up since") {
+		t.Fatalf("Could not confirm TOTP: %d %s", resp.StatusCode, body)
+	}
+
+	// The code used to confirm can't be used again.
+	login := app.NewBrowser(t)
+	resp, body = login.PostForm("/login/totp", url.Values{"kthid": {"nollan"}, "code": {code}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a replayed code to be rejected, got %d %s", resp.StatusCode, body)
+	}
+	code, err = totp.GenerateCode(secret.Secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body = login.PostForm("/login/totp", url.Values{"kthid": {"nollan"}, "code": {code}})
+	expectAccountPage(t, resp, body)
+
+	admin := app.NewBrowser(t)
+	emailLogin(t, app, admin, "turetek")
+	req, err := http.NewRequest(http.MethodDelete, app.URL.JoinPath("/admin/users/nollan/totp").String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := admin.Do(req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not reset TOTP: %d %s", resp.StatusCode, body)
+	}
+	if _, body := b.Get("/account"); !strings.Contains(body, "Set up authenticator app") {
+		t.Fatalf("Expected TOTP to be removed: %s", body)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "turetek")
+
+	resp, body := b.PostForm("/account/recovery-codes", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not generate recovery codes: %d %s", resp.StatusCode, body)
+	}
+	codes := regexp.MustCompile(`<code>((?:[A-Z2-7]{4}-){3}[A-Z2-7]{4})</code>`).FindAllStringSubmatch(body, -1)
+	if len(codes) != 10 {
+		t.Fatalf("Expected 10 recovery codes, got %d: %s", len(codes), body)
+	}
+
+	login := app.NewBrowser(t)
+	if resp, body := login.PostForm("/login/recovery-code", url.Values{"kthid": {"turetek"}, "code": {"AAAA-AAAA-AAAA-AAAA"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid code to be rejected, got %d %s", resp.StatusCode, body)
+	}
+	code := strings.ToLower(codes[0][1])
+	resp, body = login.PostForm("/login/recovery-code", url.Values{"kthid": {"turetek"}, "code": {code}})
+	expectAccountPage(t, resp, body)
+	if !strings.Contains(body, "9 unused recovery codes") {
+		t.Fatalf("Expected the recovery code to be used up: %s", body)
+	}
+	email, ok := app.Upstreams.Spam.LastEmailTo("turetek@kth.se")
+	if !ok || !strings.Contains(email.Content, "recovery code was used") {
+		t.Fatalf("Expected a notification email, got %+v", email)
+	}
+
+	login = app.NewBrowser(t)
+	if resp, body := login.PostForm("/login/recovery-code", url.Values{"kthid": {"turetek"}, "code": {code}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a used recovery code to be rejected, got %d %s", resp.StatusCode, body)
+	}
+
+	entries, err := app.Service.ListAuditLog(context.Background(), models.AuditFilter{Action: "recovery-code.use"}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "turetek" {
+		t.Fatalf("Expected the use to be audited once, got %+v", entries)
+	}
+}
+
+func TestPasskeyCloneWarning(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "turetek")
+	addPasskey(t, b, "laptop")
+
+	thief := app.NewBrowser(t)
+	thief.Authenticator = b.Authenticator.Clone()
+	for _, browser := range []*testutil.Browser{b, thief} {
+		if resp, body := passkeyLogin(t, browser); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Could not log in with passkey: %d %s", resp.StatusCode, body)
+		}
+	}
+
+	passkeys, err := app.Service.ListPasskeysForUser(context.Background(), "turetek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || !passkeys[0].CloneWarning {
+		t.Fatalf("Expected the passkey to be flagged, got %+v", passkeys)
+	}
+	entries, err := app.Service.ListAuditLog(context.Background(), models.AuditFilter{Action: "passkey.clone-warning"}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected the clone warning to be audited once, got %+v", entries)
+	}
+}
+
+func TestAdminPasskeyAttestation(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	createUser(t, app, "nollan")
+	app.Upstreams.Hive.Set("turetek", "sso", fakehive.Permission{ID: "manage-members"})
+	yubikey := uuid.MustParse("cb69481e-8ff7-4039-93ec-0a2729a154a8")
+	app.Cfg.WebAuthnAdminAAGUIDs = []uuid.UUID{yubikey}
+	mds, err := memory.New(memory.WithMetadata(map[uuid.UUID]*metadata.Entry{
+		yubikey: {AaGUID: yubikey},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Service.MDS = mds
+
+	// The virtual authenticator only does "none" attestation, so nothing vouches for the AAGUID it
+	// reports.
+	admin := app.NewBrowser(t)
+	admin.Authenticator.AAGUID = yubikey
+	emailLogin(t, app, admin, "turetek")
+	if resp, body := postPasskey(t, admin, "yubikey"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected an unattested passkey to be rejected for an admin, got %d %s", resp.StatusCode, body)
+	}
+
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "nollan")
+	addPasskey(t, b, "laptop")
+}
+
+func TestDeviceLogin(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+
+	laptop := app.NewBrowser(t)
+	resp, body := laptop.PostForm("/login/device/begin", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not begin device login: %d %s", resp.StatusCode, body)
+	}
+	match := regexp.MustCompile(`<code>([A-Z2-9]{4}-[A-Z2-9]{4})</code>`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("No code in response: %s", body)
+	}
+	if resp, body := laptop.Get("/login/device/poll"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected the login to be pending, got %d %s", resp.StatusCode, body)
+	}
+
+	// Someone else can't use the code, since they don't have the cookie.
+	if resp, body := app.NewBrowser(t).Get("/login/device/qr.png"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected no device login without the cookie, got %d %s", resp.StatusCode, body)
+	}
+
+	phone := app.NewBrowser(t)
+	emailLogin(t, app, phone, "turetek")
+	if resp, body := phone.Get("/login/device?code=" + strings.ToLower(match[1])); resp.StatusCode != http.StatusOK || !strings.Contains(body, "Approve") {
+		t.Fatalf("Expected to be asked for approval, got %d %s", resp.StatusCode, body)
+	}
+	if resp, body := phone.PostForm("/login/device/approve", url.Values{"code": {match[1]}}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not approve device login: %d %s", resp.StatusCode, body)
+	}
+
+	laptop.Get("/login/device/poll")
+	resp, body = laptop.Get("/account")
+	expectAccountPage(t, resp, body)
+
+	if resp, body := laptop.Get("/login/device/poll"); !strings.Contains(body, "expired") {
+		t.Fatalf("Expected the device login to be used up, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := testutil.NewApp(t)
+	app.Cfg.RateLimitLoginAccount = config.RateLimit{Limit: 2, Window: time.Hour}
+	app.Cfg.RateLimitAlertEmail = "d-sys@kth.se"
+	createUser(t, app, "turetek")
+
+	b := app.NewBrowser(t)
+	for range 2 {
+		beginEmailLogin(t, app, b, "turetek")
+	}
+	resp, body := app.NewBrowser(t).PostForm("/login/email/begin", url.Values{"kthid": {"turetek"}})
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("Expected to be rate limited with Retry-After, got %d %v %s", resp.StatusCode, resp.Header, body)
+	}
+	// Guessing codes shares the budget with sending them.
+	for _, path := range []string{"/login/totp", "/login/recovery-code"} {
+		resp, _ := app.NewBrowser(t).PostForm(path, url.Values{"kthid": {"turetek"}, "code": {"123456"}})
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("Expected %s to be rate limited, got %d", path, resp.StatusCode)
+		}
+	}
+	// Other accounts are unaffected.
+	createUser(t, app, "mrjolt")
+	beginEmailLogin(t, app, b, "mrjolt")
+
+	app.NewBrowser(t).PostForm("/login/email/begin", url.Values{"kthid": {"turetek"}})
+	entries, err := app.Service.ListAuditLog(context.Background(), models.AuditFilter{Action: "rate-limit.exceed"}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "turetek" {
+		t.Fatalf("Expected one alert in the audit log, got %+v", entries)
+	}
+	if _, ok := app.Upstreams.Spam.LastEmailTo("d-sys@kth.se"); !ok {
+		t.Fatal("Expected an alert email")
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "turetek")
+
+	post := func(header, value string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, app.URL.JoinPath("/account/recovery-codes").String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(header, value)
+		resp, _ := b.Do(req)
+		return resp
+	}
+	if resp := post(httputil.CSRFHeader, "wrong"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a wrong CSRF token to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := post("Origin", "https://evil.example"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected another origin to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := post("Origin", app.URL.Scheme+"://"+app.URL.Host); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a same-origin request with the token to work, got %d", resp.StatusCode)
+	}
+
+	if _, body := b.Get("/account"); !regexp.MustCompile(`<meta name="csrf-token" content="[A-Z2-7]+">`).MatchString(body) {
+		t.Fatalf("No CSRF token in page: %s", body)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	app := testutil.NewApp(t)
+	resp, body := app.NewBrowser(t).Get("/")
+	csp := resp.Header.Get("Content-Security-Policy")
+	m := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(csp)
+	if m == nil || !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Fatalf("Unexpected Content-Security-Policy %q", csp)
+	}
+	if !strings.Contains(body, `<script nonce="`+m[1]+`"`) {
+		t.Fatalf("Expected the scripts to have the nonce %s: %s", m[1], body)
+	}
+	for _, header := range []string{"Strict-Transport-Security", "Referrer-Policy", "Permissions-Policy", "X-Frame-Options"} {
+		if resp.Header.Get(header) == "" {
+			t.Errorf("Missing %s header", header)
+		}
+	}
+
+	app.Cfg.CSPReportOnly = true
+	resp, _ = app.NewBrowser(t).Get("/")
+	if resp.Header.Get("Content-Security-Policy") != "" || resp.Header.Get("Content-Security-Policy-Report-Only") == "" {
+		t.Fatalf("Expected only a report-only policy, got %v", resp.Header)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "turetek")
+	b.PostForm("/login/totp", url.Values{"kthid": {"turetek"}, "code": {"123456"}})
+
+	resp, body := b.Get("/metrics")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get metrics: %d %s", resp.StatusCode, body)
+	}
+	for _, metric := range []string{
+		`sso_logins_total{method="email",outcome="success"}`,
+		`sso_logins_total{method="totp",outcome="failure"}`,
+		`sso_http_request_duration_seconds_count{code="200",method="POST",route="POST /login/email/begin"}`,
+		`sso_upstream_request_duration_seconds_count{code="200",method="GET",upstream="hive"}`,
+	} {
+		if !strings.Contains(body, metric) {
+			t.Errorf("Missing %s in metrics", metric)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "turetek")
+
+	req, err := http.NewRequest(http.MethodGet, app.URL.JoinPath("/").String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	if resp, body := b.Do(req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Could not get index: %d %s", resp.StatusCode, body)
+	}
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			names = append(names, span.Name())
+		}
+	}
+	for _, name := range []string{"GET /{$}", "handlers.index", "GetSession"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("Missing span %s in trace, got %v", name, names)
+		}
+	}
+}
+
+// syncBuffer is written to by the server while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestLogging(t *testing.T) {
+	var logs syncBuffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(slog.NewJSONHandler(&logs, nil))))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	app := testutil.NewApp(t)
+	createUser(t, app, "turetek")
+	b := app.NewBrowser(t)
+	emailLogin(t, app, b, "turetek")
+
+	resp, _ := b.Get("/")
+	if id := resp.Header.Get(logging.RequestIDHeader); id == "" {
+		t.Error("No request id was assigned")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, app.URL.JoinPath("/").String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(logging.RequestIDHeader, "from-the-proxy")
+	resp, _ = b.Do(req)
+	if id := resp.Header.Get(logging.RequestIDHeader); id != "from-the-proxy" {
+		t.Errorf("Got request id %q, expected the one that was sent", id)
+	}
+
+	var found bool
+	for line := range strings.Lines(logs.String()) {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] != "Request" || record["request_id"] != "from-the-proxy" {
+			continue
+		}
+		found = true
+		if record["route"] != "GET /{$}" || record["status"] != float64(200) || record["kthid"] != "turetek" {
+			t.Errorf("Unexpected access log line %s", line)
+		}
+	}
+	if !found {
+		t.Errorf("No access log line for the request, got:\n%s", logs.String())
+	}
+}
@@ -2,17 +2,23 @@ package handlers
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	_ "embed"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"math/big"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/datasektionen/sso/database"
-	"github.com/datasektionen/sso/pkg/config"
-	"github.com/datasektionen/sso/pkg/email"
+	"github.com/datasektionen/sso/models"
 	"github.com/datasektionen/sso/pkg/httputil"
 	"github.com/datasektionen/sso/service"
 	"github.com/datasektionen/sso/templates"
@@ -84,6 +90,15 @@ func parseCode(text string) (string, error) {
 	return res, nil
 }
 
+// emailLoginCookie identifies the browser that asked for a login email, so that the link in it can
+// log that browser in directly but has to be confirmed anywhere else.
+const emailLoginCookie = "_sso_email-login"
+
+func hashLinkToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
 func beginLoginEmail(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
 	kthid := r.FormValue("kthid")
 	user, err := s.GetUser(r.Context(), kthid)
@@ -95,23 +110,48 @@ func beginLoginEmail(s *service.Service, w http.ResponseWriter, r *http.Request)
 	}
 
 	code := randomCode()
+	token := rand.Text()
+	browser := rand.Text()
+	if c, _ := r.Cookie(emailLoginCookie); c != nil && c.Value != "" {
+		browser = c.Value
+	}
 
 	if err := s.DB.BeginEmailLogin(
 		r.Context(),
-		database.BeginEmailLoginParams{Kthid: kthid, Code: code},
+		database.BeginEmailLoginParams{
+			Kthid:     kthid,
+			Code:      code,
+			LinkToken: hashLinkToken(token),
+			Browser:   browser,
+		},
 	); err != nil {
 		return err
 	}
 
-	if err := email.Send(
+	link := s.Cfg.Origin.JoinPath("/login/email/link")
+	link.RawQuery = url.Values{"token": {token}}.Encode()
+	if err := s.Email.Send(
 		r.Context(),
 		user.Email,
 		"SSO - Login Code",
-		fmt.Sprintf("Your login code to [Datasektionen SSO](%s) is `%s`", config.Config.Origin, code),
+		fmt.Sprintf(
+			"Your login code to [Datasektionen SSO](%s) is `%s`\n\n"+
+				"You can also log in by opening [this link](%s). It can only be used once and expires in 10 minutes.",
+			s.Cfg.Origin, code, link,
+		),
 	); err != nil {
 		return err
 	}
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     emailLoginCookie,
+		Value:    browser,
+		Path:     "/login/email",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   !s.Cfg.Dev,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 	return templates.CodeForm(kthid)
 }
 
@@ -133,9 +173,9 @@ func finishLoginEmail(s *service.Service, w http.ResponseWriter, r *http.Request
 	}
 
 	if res.Ok {
-		return s.LoginUser(r.Context(), kthid, true)
+		return s.LoginUser(r, kthid, models.LoginMethodEmail, true)
 	} else {
-		slog.Info("Failed email login", "kthid", kthid, "code", code, "reason", res.Reason)
+		slog.InfoContext(r.Context(), "Failed email login", "kthid", kthid, "code", code, "reason", res.Reason)
 		msg := "Code is invalid for an unkown reason. (please tell d-sys)"
 		switch res.Reason {
 		case "expired":
@@ -150,3 +190,32 @@ func finishLoginEmail(s *service.Service, w http.ResponseWriter, r *http.Request
 		return httputil.BadRequest(msg)
 	}
 }
+
+// emailLoginLink logs in directly when the link is opened in the browser that asked for it, and
+// otherwise asks for confirmation. That also keeps link scanners in mail clients from using it up.
+func emailLoginLink(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	token := r.FormValue("token")
+	login, err := s.DB.GetEmailLoginByLink(r.Context(), hashLinkToken(token))
+	if err == pgx.ErrNoRows {
+		return templates.EmailLinkInvalid()
+	}
+	if err != nil {
+		return err
+	}
+	c, _ := r.Cookie(emailLoginCookie)
+	if c == nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(login.Browser)) != 1 {
+		return templates.EmailLinkConfirm(login.Kthid, token)
+	}
+	return finishLoginEmailLink(s, w, r)
+}
+
+func finishLoginEmailLink(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid, err := s.DB.FinishEmailLoginByLink(r.Context(), hashLinkToken(r.FormValue("token")))
+	if err == pgx.ErrNoRows {
+		return templates.EmailLinkInvalid()
+	}
+	if err != nil {
+		return err
+	}
+	return s.LoginUser(r, kthid, models.LoginMethodEmail, true)
+}
@@ -6,9 +6,7 @@ import (
 	"strconv"
 
 	"github.com/datasektionen/sso/database"
-	"github.com/datasektionen/sso/pkg/config"
 	"github.com/datasektionen/sso/pkg/httputil"
-	"github.com/datasektionen/sso/pkg/rfinger"
 	"github.com/datasektionen/sso/service"
 )
 
@@ -41,9 +39,9 @@ func apiListUsers(s *service.Service, w http.ResponseWriter, r *http.Request) ht
 	if r.FormValue("picture") == "full" || r.FormValue("picture") == "thumbnail" {
 		var err error
 		if r.FormValue("format") == "single" {
-			pictures[q[0]], err = rfinger.GetPicture(r.Context(), q[0], r.FormValue("picture") == "full")
+			pictures[q[0]], err = s.Rfinger.GetPicture(r.Context(), q[0], r.FormValue("picture") == "full")
 		} else {
-			pictures, err = rfinger.GetPictures(r.Context(), q, r.FormValue("picture") == "full")
+			pictures, err = s.Rfinger.GetPictures(r.Context(), q, r.FormValue("picture") == "full")
 		}
 
 		if err != nil {
@@ -104,12 +102,12 @@ func searchUsers(s *service.Service, r *http.Request, searchParam string, limit
 	}
 
 	pictures := make(map[string]string, len(dbUsers))
-	if includePictures && len(dbUsers) > 0 && config.Config.RfingerURL != nil {
+	if includePictures && len(dbUsers) > 0 && s.Rfinger.Enabled() {
 		pictureUsers := make([]string, len(dbUsers))
 		for i, user := range dbUsers {
 			pictureUsers[i] = user.Kthid
 		}
-		pictures, err = rfinger.GetPictures(r.Context(), pictureUsers, fullQualityPictures)
+		pictures, err = s.Rfinger.GetPictures(r.Context(), pictureUsers, fullQualityPictures)
 		if err != nil {
 			slog.WarnContext(r.Context(), "Could not fetch member pictures from rfinger", "error", err)
 			pictures = map[string]string{}
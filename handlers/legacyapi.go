@@ -7,9 +7,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/datasektionen/sso/pkg/config"
 	"github.com/datasektionen/sso/pkg/httputil"
-	"github.com/datasektionen/sso/pkg/pls"
 	"github.com/datasektionen/sso/service"
 	"github.com/datasektionen/sso/templates"
 	"github.com/google/uuid"
@@ -49,7 +47,7 @@ func legacyAPILogin(s *service.Service, w http.ResponseWriter, r *http.Request)
 		Value:    callback.String(),
 		Path:     "/legacyapi",
 		MaxAge:   int((time.Minute * 10).Seconds()),
-		Secure:   !config.Config.Dev,
+		Secure:   !s.Cfg.Dev,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	})
@@ -69,6 +67,10 @@ func legacyAPICallback(s *service.Service, w http.ResponseWriter, r *http.Reques
 	if user == nil {
 		return httputil.BadRequest("You did not seem to get logged in")
 	}
+	// Unlike ID tokens, there's no way to tell systems using the legacy API who's really logged in.
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
 	token, err := s.DB.CreateToken(r.Context(), user.KTHID)
 	if err != nil {
 		return err
@@ -82,7 +84,7 @@ func legacyAPIVerify(s *service.Service, w http.ResponseWriter, r *http.Request)
 		return httputil.BadRequest("Invalid token")
 	}
 	apiKey := r.FormValue("api_key")
-	allowed, err := pls.CheckToken(r.Context(), apiKey, "login.login")
+	allowed, err := s.Pls.CheckToken(r.Context(), apiKey, "login.login")
 	if err != nil {
 		return err
 	}
@@ -51,13 +51,13 @@ func kthCallback(s *service.Service, w http.ResponseWriter, r *http.Request) htt
 		kthidAny := tokens.IDTokenClaims.Claims["username"]
 		kthid, ok := kthidAny.(string)
 		if !ok {
-			slog.Error("Could not find a kth-id, or it wasn't a string", "claims", tokens.IDTokenClaims)
+			slog.ErrorContext(r.Context(), "Could not find a kth-id, or it wasn't a string", "claims", tokens.IDTokenClaims)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		user, err := s.GetUser(r.Context(), kthid)
 		if err != nil {
-			slog.Error("Could not get user", "kthid", kthid, "error", err)
+			slog.ErrorContext(r.Context(), "Could not get user", "kthid", kthid, "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -79,7 +79,8 @@ func kthCallback(s *service.Service, w http.ResponseWriter, r *http.Request) htt
 			}
 			givenName, familyName, found := strings.Cut(name, " ")
 			if !found {
-				slog.Warn(
+				slog.WarnContext(
+					r.Context(),
 					"Did not manage to find a reasonable name from kth user to create guest session. Proceeding as ${kthid} ${kthid}sson",
 					"given name", givenName,
 					"family name", familyName,
@@ -89,13 +90,13 @@ func kthCallback(s *service.Service, w http.ResponseWriter, r *http.Request) htt
 				familyName = kthid + "sson"
 			}
 
-			httputil.Respond(s.LoginGuestUser(r.Context(), models.GuestUser{
+			httputil.Respond(s.LoginGuestUser(r, models.GuestUser{
 				KTHID:      kthid,
 				FirstName:  givenName,
 				FamilyName: familyName,
 			}, true), w, r)
 			return
 		}
-		httputil.Respond(s.LoginUser(r.Context(), user.KTHID, true), w, r)
+		httputil.Respond(s.LoginUser(r, user.KTHID, models.LoginMethodKTH, true), w, r)
 	}, s.RelyingParty)
 }
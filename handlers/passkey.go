@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/datasektionen/sso/database"
@@ -37,13 +40,13 @@ func beginLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Reques
 			w.Header().Add("HX-Reswap", "beforeend")
 			return `<p class="error">You have no registered passkeys</p>`
 		}
-		credAss, sessionData, err = s.WebAuthn.BeginLogin(models.WebAuthnUser{User: user, Passkeys: passkeys})
+		credAss, sessionData, err = s.WebAuthn.BeginLogin(models.WebAuthnUser{User: user, Passkeys: passkeys}, webauthn.WithUserVerification(protocol.VerificationRequired))
 		if err != nil {
 			return err
 		}
 	} else {
 		var err error
-		credAss, sessionData, err = s.WebAuthn.BeginDiscoverableLogin()
+		credAss, sessionData, err = s.WebAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
 		if err != nil {
 			return err
 		}
@@ -60,6 +63,25 @@ func beginLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Reques
 	return templates.PasskeyLoginForm(kthid, credAss, sessionID)
 }
 
+// beginAutofillPasskey starts a discoverable login with conditional mediation when the index page
+// loads, so that the browser can suggest passkeys in the username field. Nothing happens until the
+// user picks one, so if they don't, the session data is left for the cleanup job.
+func beginAutofillPasskey(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	credAss, sessionData, err := s.WebAuthn.BeginDiscoverableMediatedLogin(protocol.MediationConditional, webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return err
+	}
+	sessionDataBytes, err := json.Marshal(sessionData)
+	if err != nil {
+		return err
+	}
+	sessionID, err := s.DB.StoreWebAuthnSessionData(r.Context(), database.StoreWebAuthnSessionDataParams{Data: sessionDataBytes})
+	if err != nil {
+		return err
+	}
+	return httputil.JSON(map[string]any{"credAss": credAss, "session": sessionID})
+}
+
 func finishLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
 	var body struct {
 		SessionID uuid.UUID                            `json:"session"`
@@ -74,6 +96,9 @@ func finishLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Reque
 	}
 
 	session, err := s.DB.TakeWebAuthnSessionData(r.Context(), body.SessionID)
+	if err == pgx.ErrNoRows {
+		return httputil.BadRequest("The login has expired, please reload the page and try again")
+	}
 	if err != nil {
 		return err
 	}
@@ -82,6 +107,9 @@ func finishLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Reque
 		return err
 	}
 	var kthid string
+	// As it was before logging in.
+	var passkey models.Passkey
+	var cred *webauthn.Credential
 	if session.Kthid != "" {
 		user, err := s.GetUser(r.Context(), session.Kthid)
 		if err != nil {
@@ -94,31 +122,39 @@ func finishLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Reque
 		if err != nil {
 			return err
 		}
-		_, err = s.WebAuthn.ValidateLogin(models.WebAuthnUser{User: user, Passkeys: passkeys}, sessionData, credAss)
+		cred, err = s.WebAuthn.ValidateLogin(models.WebAuthnUser{User: user, Passkeys: passkeys}, sessionData, credAss)
 		if err != nil {
 			return err
 		}
+		for _, p := range passkeys {
+			if bytes.Equal(p.Cred.ID, cred.ID) {
+				passkey = p
+			}
+		}
 		kthid = user.KTHID
 	} else {
-		waUser, _, err := s.WebAuthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (user webauthn.User, err error) {
+		var waUser webauthn.User
+		var err error
+		waUser, cred, err = s.WebAuthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (user webauthn.User, err error) {
 			kthid, err := s.DB.GetKTHIDByWebauthnID(r.Context(), userHandle)
 			if err != nil {
 				return nil, err
 			}
-			passkey, err := s.GetPasskey(r.Context(), kthid, rawID)
+			p, err := s.GetPasskey(r.Context(), kthid, rawID)
 			if err != nil {
 				return nil, err
 			}
-			if passkey == nil {
+			if p == nil {
 				return nil, httputil.BadRequest("Invalid credential")
 			}
+			passkey = *p
 			// NOTE: object oriented programming is the scourge upon this earth. The only fields that
 			// ValidatePasskeyLogin will read is WebAuthnID and the passkey that has the id `rawID`.
 			// and the only field that we want is the kth id. So while some fields are not filled in:
 			// WHO CARES!??
 			return models.WebAuthnUser{
 				User:     &models.User{KTHID: kthid, WebAuthnID: userHandle},
-				Passkeys: []models.Passkey{*passkey},
+				Passkeys: []models.Passkey{passkey},
 			}, nil
 		}, sessionData, credAss)
 		if err == pgx.ErrNoRows {
@@ -129,8 +165,14 @@ func finishLoginPasskey(s *service.Service, w http.ResponseWriter, r *http.Reque
 		}
 		kthid = waUser.WebAuthnName()
 	}
+	if err := s.CheckPasskeyAllowed(r.Context(), kthid, cred); err != nil {
+		return err
+	}
+	if err := s.RecordPasskeyUse(r, passkey, cred); err != nil {
+		return err
+	}
 
-	return s.LoginUser(r.Context(), kthid, false)
+	return s.LoginUser(r, kthid, models.LoginMethodPasskey, false)
 }
 
 func addPasskeyForm(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
@@ -138,7 +180,11 @@ func addPasskeyForm(s *service.Service, w http.ResponseWriter, r *http.Request)
 	if user == nil {
 		return httputil.Unauthorized()
 	}
-	creation, sessionData, err := s.WebAuthn.BeginRegistration(models.WebAuthnUser{User: user}, webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{ResidentKey: protocol.ResidentKeyRequirementRequired}))
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	// Attestation and authenticator selection are set in the config in initWebAuthn.
+	creation, sessionData, err := s.WebAuthn.BeginRegistration(models.WebAuthnUser{User: user})
 	if err != nil {
 		return err
 	}
@@ -159,6 +205,9 @@ func addPasskey(s *service.Service, w http.ResponseWriter, r *http.Request) http
 	if user == nil {
 		return httputil.Unauthorized()
 	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
 
 	var body struct {
 		Name      string    `json:"name"`
@@ -187,6 +236,9 @@ func addPasskey(s *service.Service, w http.ResponseWriter, r *http.Request) http
 	if err != nil {
 		return err
 	}
+	if err := s.CheckPasskeyAllowed(r.Context(), user.KTHID, cred); err != nil {
+		return err
+	}
 	name := body.Name
 	if name == "" {
 		name = time.Now().Format(time.DateOnly)
@@ -201,8 +253,51 @@ func addPasskey(s *service.Service, w http.ResponseWriter, r *http.Request) http
 	if err != nil {
 		return err
 	}
-	passkey := models.Passkey{ID: id, Name: name, Discoverable: true}
-	return templates.ShowPasskey(passkey)
+	s.Audit(r, "passkey.add", user.KTHID, nil, map[string]any{"passkey": id, "name": name})
+	passkey, err := s.GetPasskey(r.Context(), user.KTHID, cred.ID)
+	if err != nil {
+		return err
+	}
+	if passkey == nil {
+		return httputil.NotFound()
+	}
+	return templates.ShowPasskey(*passkey)
+}
+
+func renamePasskey(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	passkeyID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return httputil.BadRequest("Invalid uuid")
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return httputil.BadRequest("The name can't be empty")
+	}
+	passkeys, err := s.ListPasskeysForUser(r.Context(), user.KTHID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(passkeys, func(p models.Passkey) bool { return p.ID == passkeyID })
+	if i == -1 {
+		return httputil.NotFound()
+	}
+	if err := s.DB.RenamePasskey(r.Context(), database.RenamePasskeyParams{
+		Kthid: user.KTHID,
+		ID:    passkeyID,
+		Name:  name,
+	}); err != nil {
+		return err
+	}
+	s.Audit(r, "passkey.rename", user.KTHID, map[string]any{"passkey": passkeyID, "name": passkeys[i].Name}, map[string]any{"passkey": passkeyID, "name": name})
+	passkeys[i].Name = name
+	return templates.ShowPasskey(passkeys[i])
 }
 
 func removePasskey(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
@@ -210,6 +305,9 @@ func removePasskey(s *service.Service, w http.ResponseWriter, r *http.Request) h
 	if user == nil {
 		return httputil.Unauthorized()
 	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
 	passkeyID, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		return httputil.BadRequest("Invalid uuid")
@@ -220,5 +318,6 @@ func removePasskey(s *service.Service, w http.ResponseWriter, r *http.Request) h
 	}); err != nil {
 		return err
 	}
+	s.Audit(r, "passkey.remove", user.KTHID, map[string]any{"passkey": passkeyID}, nil)
 	return nil
 }
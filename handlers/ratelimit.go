@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/service"
+)
+
+// rateLimit only lets requests through to h while they're within the policy for the key returned by
+// keyGetter. The policy is read on every request so that it can be changed in tests.
+func rateLimit(s *service.Service, h http.Handler, name string, policy *config.RateLimit, keyGetter func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.RateLimit(r, name, *policy, keyGetter(r)); err != nil {
+			httputil.Respond(err, w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loginRateLimit limits logging in per client IP.
+func loginRateLimit(s *service.Service, h http.Handler) http.Handler {
+	return rateLimit(s, h, "login", &s.Cfg.RateLimitLogin, httputil.ClientIP)
+}
+
+func byKTHID(r *http.Request) string {
+	return r.FormValue("kthid")
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/service"
+	"github.com/datasektionen/sso/templates"
+)
+
+func loginRecoveryCode(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid := r.FormValue("kthid")
+	if ok, err := s.UseRecoveryCode(r, kthid, r.FormValue("code")); err != nil {
+		return err
+	} else if !ok {
+		return httputil.BadRequest("Invalid recovery code")
+	}
+	return s.LoginUser(r, kthid, models.LoginMethodRecoveryCode, true)
+}
+
+func generateRecoveryCodes(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	codes, err := s.GenerateRecoveryCodes(r, user.KTHID)
+	if err != nil {
+		return err
+	}
+	return templates.RecoveryCodeSettings(len(codes), codes)
+}
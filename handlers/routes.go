@@ -3,12 +3,18 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/datasektionen/sso/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/datasektionen/sso/models"
 	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/pkg/metrics"
 	"github.com/datasektionen/sso/service"
 )
 
-func MountRoutes(s *service.Service, mux *http.ServeMux, includeInternal bool) {
+func MountRoutes(s *service.Service, serveMux *http.ServeMux, includeInternal bool) {
+	// Every route gets a CSRF token, and every route that changes something requires it.
+	mux := httputil.NewCSRFMux(serveMux, s.Cfg)
+
 	mux.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("Pong! Regards, SSO"))
 	})
@@ -23,8 +29,10 @@ func MountRoutes(s *service.Service, mux *http.ServeMux, includeInternal bool) {
 	mux.Handle("POST /account/email/change", httputil.Route(s, beginEmailChange))
 	mux.Handle("POST /account/email/verify", httputil.Route(s, finishEmailChange))
 	mux.Handle("POST /account/email/cancel", httputil.Route(s, cancelEmailChange))
+	mux.Handle("DELETE /account/sessions/{id}", httputil.Route(s, removeSession))
+	mux.Handle("DELETE /account/sessions", httputil.Route(s, logoutEverywhere))
 	mux.Handle("GET /request-account", httputil.Route(s, requestAccountPage))
-	mux.Handle("POST /request-account", httputil.Route(s, requestAccount))
+	mux.Handle("POST /request-account", rateLimit(s, httputil.Route(s, requestAccount), "request-account", &s.Cfg.RateLimitRequestAccount, httputil.ClientIP))
 	mux.Handle("GET /request-account/done", httputil.Route(s, requestAccountDone))
 	mux.Handle("GET /invite/{id}", httputil.Route(s, acceptInvite))
 
@@ -34,6 +42,12 @@ func MountRoutes(s *service.Service, mux *http.ServeMux, includeInternal bool) {
 	mux.Handle("GET /admin/users/{kthid}", authorize(s, httputil.Route(s, adminUser), "read-members", nil))
 	mux.Handle("GET /admin/users/{kthid}/edit", authorize(s, httputil.Route(s, editAdminUserForm), "manage-members", nil))
 	mux.Handle("PUT /admin/users/{kthid}", authorize(s, httputil.Route(s, updateAdminUser), "manage-members", nil))
+	mux.Handle("POST /admin/users/{kthid}/refresh-permissions", authorize(s, httputil.Route(s, refreshUserPermissions), "manage-members", nil))
+	mux.Handle("GET /admin/users/{kthid}/sessions", authorize(s, httputil.Route(s, adminUserSessions), "read-members", nil))
+	mux.Handle("DELETE /admin/users/{kthid}/sessions/{id}", authorize(s, httputil.Route(s, adminRemoveUserSession), "manage-members", nil))
+	mux.Handle("DELETE /admin/users/{kthid}/sessions", authorize(s, httputil.Route(s, adminRemoveUserSessions), "manage-members", nil))
+	mux.Handle("POST /admin/users/{kthid}/impersonate", authorize(s, httputil.Route(s, impersonateUser), "impersonate-users", nil))
+	mux.Handle("POST /impersonation/stop", httputil.Route(s, stopImpersonating))
 	mux.Handle("POST /admin/members/upload-sheet", authorize(s, httputil.Route(s, uploadSheet), "write-members", nil))
 	mux.Handle("GET /admin/members/upload-sheet", authorize(s, httputil.Route(s, processSheet), "write-members", nil))
 
@@ -59,27 +73,57 @@ func MountRoutes(s *service.Service, mux *http.ServeMux, includeInternal bool) {
 	mux.Handle("POST /admin/name-change-requests/{kthid}", authorize(s, httputil.Route(s, approveNameChangeRequest), "manage-name-change-requests", nil))
 	mux.Handle("DELETE /admin/name-change-requests/{kthid}", authorize(s, httputil.Route(s, denyNameChangeRequest), "manage-name-change-requests", nil))
 
+	// audit.go
+	mux.Handle("GET /admin/audit", authorize(s, httputil.Route(s, auditLog), "read-audit-log", nil))
+	mux.Handle("GET /admin/audit/export.csv", authorize(s, httputil.Route(s, exportAuditLog), "read-audit-log", nil))
+
 	// dev.go
-	if config.Config.Dev {
+	if s.Cfg.Dev {
 		mux.Handle("POST /login/dev", httputil.Route(s, devLogin))
 		mux.Handle("GET /dev/auto-reload", httputil.Route(s, autoReload))
 	}
 
 	// passkey.go
-	mux.Handle("POST /login/passkey/begin", httputil.Route(s, beginLoginPasskey))
-	mux.Handle("POST /login/passkey/finish", httputil.Route(s, finishLoginPasskey))
+	mux.Handle("POST /login/passkey/begin", loginRateLimit(s, httputil.Route(s, beginLoginPasskey)))
+	// Called on every load of the login page, so it can't share a budget with actual logins.
+	mux.Handle("POST /login/passkey/autofill", rateLimit(s, httputil.Route(s, beginAutofillPasskey), "passkey-autofill", &s.Cfg.RateLimitPasskeyAutofill, httputil.ClientIP))
+	mux.Handle("POST /login/passkey/finish", loginRateLimit(s, metrics.LoginAttempts(models.LoginMethodPasskey, httputil.Route(s, finishLoginPasskey))))
 
 	mux.Handle("GET /passkey/add-form", httputil.Route(s, addPasskeyForm))
 	mux.Handle("POST /passkey", httputil.Route(s, addPasskey))
+	mux.Handle("PATCH /passkey/{id}", httputil.Route(s, renamePasskey))
 	mux.Handle("DELETE /passkey/{id}", httputil.Route(s, removePasskey))
 
 	// emaillogin.go
-	mux.Handle("POST /login/email/begin", httputil.Route(s, beginLoginEmail))
-	mux.Handle("POST /login/email/finish", httputil.Route(s, finishLoginEmail))
+	mux.Handle("POST /login/email/begin", loginRateLimit(s, rateLimit(s, httputil.Route(s, beginLoginEmail), "login-account", &s.Cfg.RateLimitLoginAccount, byKTHID)))
+	mux.Handle("POST /login/email/finish", loginRateLimit(s, rateLimit(s, metrics.LoginAttempts(models.LoginMethodEmail, httputil.Route(s, finishLoginEmail)), "login-account", &s.Cfg.RateLimitLoginAccount, byKTHID)))
+	mux.Handle("GET /login/email/link", loginRateLimit(s, httputil.Route(s, emailLoginLink)))
+	mux.Handle("POST /login/email/link", loginRateLimit(s, metrics.LoginAttempts(models.LoginMethodEmail, httputil.Route(s, finishLoginEmailLink))))
+
+	// totp.go
+	mux.Handle("POST /login/totp", loginRateLimit(s, rateLimit(s, metrics.LoginAttempts(models.LoginMethodTOTP, httputil.Route(s, loginTOTP)), "login-account", &s.Cfg.RateLimitLoginAccount, byKTHID)))
+	mux.Handle("POST /account/totp", httputil.Route(s, beginTOTPEnrollment))
+	mux.Handle("GET /account/totp/qr.png", httputil.Route(s, totpQRCode))
+	mux.Handle("POST /account/totp/confirm", httputil.Route(s, confirmTOTP))
+	mux.Handle("DELETE /account/totp", httputil.Route(s, removeTOTP))
+	mux.Handle("DELETE /admin/users/{kthid}/totp", authorize(s, httputil.Route(s, adminResetTOTP), "manage-members", nil))
+
+	// recovery.go
+	mux.Handle("POST /login/recovery-code", loginRateLimit(s, rateLimit(s, metrics.LoginAttempts(models.LoginMethodRecoveryCode, httputil.Route(s, loginRecoveryCode)), "login-account", &s.Cfg.RateLimitLoginAccount, byKTHID)))
+	mux.Handle("POST /account/recovery-codes", httputil.Route(s, generateRecoveryCodes))
+
+	// devicelogin.go
+	// There's no KTH ID until the login is approved, so it can only be limited per client IP.
+	mux.Handle("POST /login/device/begin", loginRateLimit(s, httputil.Route(s, beginDeviceLogin)))
+	mux.Handle("GET /login/device/qr.png", httputil.Route(s, deviceLoginQRCode))
+	mux.Handle("GET /login/device/poll", httputil.Route(s, pollDeviceLogin))
+	mux.Handle("GET /login/device", httputil.Route(s, deviceLoginPage))
+	mux.Handle("POST /login/device/approve", httputil.Route(s, approveDeviceLogin))
+	mux.Handle("POST /login/device/deny", httputil.Route(s, denyDeviceLogin))
 
 	// oidcrp.go
 	mux.Handle("GET /oidc/kth/login", httputil.Route(s, kthLogin))
-	mux.Handle("GET /oidc/kth/callback", httputil.Route(s, kthCallback))
+	mux.Handle("GET /oidc/kth/callback", metrics.LoginAttempts(models.LoginMethodKTH, httputil.Route(s, kthCallback)))
 
 	// legacyapi.go
 	mux.Handle("/legacyapi/hello", httputil.Route(s, legacyAPIHello))
@@ -92,5 +136,6 @@ func MountRoutes(s *service.Service, mux *http.ServeMux, includeInternal bool) {
 	if includeInternal {
 		mux.Handle("GET /api/users", httputil.Route(s, apiListUsers))
 		mux.Handle("GET /api/search", httputil.Route(s, apiSearchUsers))
+		mux.Handle("GET /metrics", promhttp.Handler())
 	}
 }
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/service"
+	"github.com/datasektionen/sso/templates"
+)
+
+func loginTOTP(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid := r.FormValue("kthid")
+	switch err := s.VerifyTOTPLogin(r.Context(), kthid, r.FormValue("code")); err {
+	case nil:
+	case service.ErrTOTPLocked:
+		return httputil.BadRequest(err.Error())
+	case service.ErrTOTPInvalid, service.ErrTOTPNotEnabled:
+		// Don't tell which users have an authenticator app.
+		return httputil.BadRequest(service.ErrTOTPInvalid.Error())
+	default:
+		return err
+	}
+	return s.LoginUser(r, kthid, models.LoginMethodTOTP, true)
+}
+
+func beginTOTPEnrollment(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	key, err := s.BeginTOTPEnrollment(r.Context(), user.KTHID)
+	if err == service.ErrTOTPAlreadyEnabled {
+		return httputil.BadRequest(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return templates.TOTPEnrollForm(key.Secret(), "")
+}
+
+func totpQRCode(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	key, err := s.PendingTOTPKey(r.Context(), user.KTHID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return httputil.NotFound()
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
+func confirmTOTP(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	err := s.ConfirmTOTP(r.Context(), user.KTHID, r.FormValue("code"))
+	if err == service.ErrTOTPInvalid {
+		key, err := s.PendingTOTPKey(r.Context(), user.KTHID)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return httputil.BadRequest("No authenticator app is being set up")
+		}
+		return templates.TOTPEnrollForm(key.Secret(), "Invalid code. Make sure the clock on your phone is correct.")
+	}
+	if err == service.ErrTOTPNotEnabled || err == service.ErrTOTPAlreadyEnabled {
+		return httputil.BadRequest(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	s.Audit(r, "totp.enable", user.KTHID, nil, nil)
+	status, err := s.GetTOTPStatus(r.Context(), user.KTHID)
+	if err != nil {
+		return err
+	}
+	return templates.TOTPSettings(status)
+}
+
+func removeTOTP(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	if removed, err := s.RemoveTOTP(r.Context(), user.KTHID); err != nil {
+		return err
+	} else if removed {
+		s.Audit(r, "totp.disable", user.KTHID, nil, nil)
+	}
+	return templates.TOTPSettings(models.TOTPStatus{})
+}
+
+func adminResetTOTP(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	kthid := r.PathValue("kthid")
+	removed, err := s.RemoveTOTP(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return httputil.BadRequest("The user has no authenticator app set up")
+	}
+	s.Audit(r, "totp.reset", kthid, nil, nil)
+	return templates.TOTPReset()
+}
@@ -12,8 +12,7 @@ import (
 	"github.com/jackc/pgx/v5"
 
 	"github.com/datasektionen/sso/database"
-	"github.com/datasektionen/sso/pkg/config"
-	"github.com/datasektionen/sso/pkg/email"
+	"github.com/datasektionen/sso/pkg/auth"
 	"github.com/datasektionen/sso/pkg/httputil"
 	"github.com/datasektionen/sso/service"
 	"github.com/datasektionen/sso/templates"
@@ -64,12 +63,12 @@ func index(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.
 			Name:     nextUrlCookie,
 			Value:    nextURL,
 			MaxAge:   int((time.Minute * 10).Seconds()),
-			Secure:   !config.Config.Dev,
+			Secure:   !s.Cfg.Dev,
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
 		})
 	}
-	return templates.Index(s.DevLoginFormOrNilComp)
+	return templates.Index(s.DevLoginFormOrNilComp, auth.WantsToBeRemembered(r))
 }
 
 func logout(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
@@ -89,11 +88,62 @@ func account(s *service.Service, w http.ResponseWriter, r *http.Request) httputi
 	if err != nil {
 		return err
 	}
+	totp, err := s.GetTOTPStatus(r.Context(), user.KTHID)
+	if err != nil {
+		return err
+	}
+	recoveryCodesLeft, err := s.DB.CountRecoveryCodes(r.Context(), user.KTHID)
+	if err != nil {
+		return err
+	}
+	sessions, err := s.ListSessions(r.Context(), user.KTHID, s.CurrentSessionID(r))
+	if err != nil {
+		return err
+	}
 	pendingEmail, err := s.DB.GetPendingEmailChange(r.Context(), user.KTHID)
 	if err != nil && err != pgx.ErrNoRows {
 		return nil
 	}
-	return templates.Account(*user, passkeys, pendingEmail)
+	return templates.Account(*user, passkeys, totp, int(recoveryCodesLeft), sessions, pendingEmail)
+}
+
+func removeSession(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return httputil.BadRequest("Invalid session id")
+	}
+	if id == s.CurrentSessionID(r) {
+		return s.Logout(w, r)
+	}
+	if ok, err := s.RemoveUserSession(r.Context(), user.KTHID, id); err != nil {
+		return err
+	} else if !ok {
+		return httputil.BadRequest("No such session")
+	}
+	s.Audit(r, "session.remove", user.KTHID, map[string]any{"session": id}, nil)
+	return ""
+}
+
+func logoutEverywhere(s *service.Service, w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	user := s.GetLoggedInUser(r)
+	if user == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
+	if err := s.RemoveUserSessions(r.Context(), user.KTHID); err != nil {
+		return err
+	}
+	s.Audit(r, "session.remove-all", user.KTHID, nil, nil)
+	return s.Logout(w, r)
 }
 
 var yearTagRegex regexp.Regexp = *regexp.MustCompile(`^[A-Z][A-Za-z]{0,3}-\d{2}$`)
@@ -103,6 +153,9 @@ func updateAccount(s *service.Service, w http.ResponseWriter, r *http.Request) h
 	if user == nil {
 		return httputil.Unauthorized()
 	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
 	if err := r.ParseForm(); err != nil {
 		return httputil.BadRequest("Invalid form body")
 	}
@@ -110,6 +163,7 @@ func updateAccount(s *service.Service, w http.ResponseWriter, r *http.Request) h
 	if err != nil && err != pgx.ErrNoRows {
 		return nil
 	}
+	before := *user
 	yearTagList := r.Form["year-tag"]
 	if len(yearTagList) > 0 {
 		yearTag := yearTagList[0]
@@ -139,6 +193,7 @@ func updateAccount(s *service.Service, w http.ResponseWriter, r *http.Request) h
 			return err
 		}
 	}
+	s.Audit(r, "account.update", user.KTHID, before, *user)
 	return templates.AccountSettingsForm(*user, pendingEmail, nil)
 }
 
@@ -149,6 +204,9 @@ func beginEmailChange(s *service.Service, w http.ResponseWriter, r *http.Request
 	if user == nil {
 		return httputil.Unauthorized()
 	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
 
 	newEmail := strings.TrimSpace(r.FormValue("new-email"))
 	if !emailRegex.MatchString(newEmail) {
@@ -165,14 +223,15 @@ func beginEmailChange(s *service.Service, w http.ResponseWriter, r *http.Request
 		return err
 	}
 
-	if err := email.Send(
+	if err := s.Email.Send(
 		r.Context(),
 		newEmail,
 		"SSO - Email Change Verification",
-		fmt.Sprintf("Your verification code to change your email address on [Datasektionen SSO](%s) is `%s`", config.Config.Origin, code),
+		fmt.Sprintf("Your verification code to change your email address on [Datasektionen SSO](%s) is `%s`", s.Cfg.Origin, code),
 	); err != nil {
 		return err
 	}
+	s.Audit(r, "email-change.begin", user.KTHID, nil, map[string]any{"new_email": newEmail})
 
 	return templates.AccountSettingsForm(*user, newEmail, nil)
 }
@@ -182,6 +241,9 @@ func finishEmailChange(s *service.Service, w http.ResponseWriter, r *http.Reques
 	if user == nil {
 		return httputil.Unauthorized()
 	}
+	if s.GetImpersonator(r) != "" {
+		return errImpersonating
+	}
 
 	code := r.FormValue("code")
 	code, err := parseCode(code)
@@ -206,6 +268,7 @@ func finishEmailChange(s *service.Service, w http.ResponseWriter, r *http.Reques
 			return err
 		}
 		updatedUser := service.DBUserToModel(newUser)
+		s.Audit(r, "email-change.finish", user.KTHID, map[string]any{"email": user.Email}, map[string]any{"email": updatedUser.Email})
 		return templates.AccountSettingsForm(updatedUser, "", nil)
 	}
 
@@ -237,6 +300,7 @@ func cancelEmailChange(s *service.Service, w http.ResponseWriter, r *http.Reques
 	if err := s.DB.CancelEmailChange(r.Context(), user.KTHID); err != nil {
 		return err
 	}
+	s.Audit(r, "email-change.cancel", user.KTHID, nil, nil)
 
 	return templates.AccountSettingsForm(*user, "", nil)
 }
@@ -269,10 +333,15 @@ func requestAccount(s *service.Service, w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			return err
 		}
+		s.Audit(r, "account-request.create", id.String(), nil, map[string]any{
+			"reference": reference,
+			"reason":    reason,
+			"year_tag":  yearTag,
+		})
 		http.SetCookie(w, &http.Cookie{
 			Name:     "account-request-id",
 			Value:    id.String(),
-			Secure:   !config.Config.Dev,
+			Secure:   !s.Cfg.Dev,
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
 		})
@@ -287,7 +356,7 @@ func requestAccount(s *service.Service, w http.ResponseWriter, r *http.Request)
 			kthid = "d-" + strings.ToLower(firstName) + "." + strings.ToLower(familyName)
 		}
 
-		if _, err := s.DB.CreateAccountRequestManual(r.Context(), database.CreateAccountRequestManualParams{
+		id, err := s.DB.CreateAccountRequestManual(r.Context(), database.CreateAccountRequestManualParams{
 			Kthid:      kthid,
 			UgKthid:    "d-ug" + kthid,
 			Reference:  reference,
@@ -296,11 +365,21 @@ func requestAccount(s *service.Service, w http.ResponseWriter, r *http.Request)
 			FirstName:  firstName,
 			FamilyName: familyName,
 			Email:      emailAddress,
-		}); err != nil {
+		})
+		if err != nil {
 			return err
 		}
+		s.Audit(r, "account-request.create", id.String(), nil, map[string]any{
+			"kthid":       kthid,
+			"email":       emailAddress,
+			"first_name":  firstName,
+			"family_name": familyName,
+			"year_tag":    yearTag,
+			"reference":   reference,
+			"reason":      reason,
+		})
 
-		if err := email.Send(
+		if err := s.Email.Send(
 			r.Context(),
 			"d-sys@datasektionen.se",
 			"Datasektionen Account Requested by "+kthid,
@@ -308,7 +387,7 @@ func requestAccount(s *service.Service, w http.ResponseWriter, r *http.Request)
 				<p>A new account request has been made by %s %s (%s).</p><a href="https://sso.datasektionen.se/admin/account-requests">sso.datasektionen.se/admin/account-requests</a>
 			`, firstName, familyName, kthid)),
 		); err != nil {
-			slog.Error("Could not send account request email", "kthid", kthid, "error", err)
+			slog.ErrorContext(r.Context(), "Could not send account request email", "kthid", kthid, "error", err)
 		}
 
 		return httputil.Redirect("/request-account/done")
@@ -347,7 +426,7 @@ func acceptInvite(s *service.Service, w http.ResponseWriter, r *http.Request) ht
 	http.SetCookie(w, &http.Cookie{
 		Name:     "invite",
 		Value:    id.String(),
-		Secure:   !config.Config.Dev,
+		Secure:   !s.Cfg.Dev,
 		HttpOnly: true,
 		Path:     "/",
 		SameSite: http.SameSiteLaxMode,
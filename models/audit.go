@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEntry struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	// Actor is the kthid of whoever did it, or empty if nobody was logged in.
+	Actor     string
+	Action    string
+	Target    string
+	Before    json.RawMessage
+	After     json.RawMessage
+	IPAddress string
+	// Impersonated is the kthid of the user the actor was impersonating, if any.
+	Impersonated string
+}
+
+type AuditChange struct {
+	// Field is empty if the values weren't objects.
+	Field  string
+	Before string
+	After  string
+}
+
+// Changes returns what differs between Before and After, field by field if
+// they're objects.
+func (e AuditEntry) Changes() []AuditChange {
+	before, beforeOK := jsonObject(e.Before)
+	after, afterOK := jsonObject(e.After)
+	if !beforeOK || !afterOK {
+		return []AuditChange{{Before: string(e.Before), After: string(e.After)}}
+	}
+	var fields []string
+	for field := range before {
+		fields = append(fields, field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	changes := make([]AuditChange, len(fields))
+	for i, field := range fields {
+		changes[i] = AuditChange{Field: field, Before: string(before[field]), After: string(after[field])}
+	}
+	return changes
+}
+
+// jsonObject parses the JSON as an object. A missing value counts as an empty
+// object.
+func jsonObject(raw json.RawMessage) (map[string]json.RawMessage, bool) {
+	var m map[string]json.RawMessage
+	if len(raw) == 0 {
+		return m, true
+	}
+	return m, json.Unmarshal(raw, &m) == nil
+}
+
+// AuditFilter narrows down which audit log entries to show. Zero values match
+// everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	// Since and Until are the first and last days to include.
+	Since time.Time
+	Until time.Time
+}
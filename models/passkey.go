@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 )
@@ -8,8 +10,16 @@ import (
 type Passkey struct {
 	ID           uuid.UUID           `json:"id"`
 	Name         string              `json:"name"`
+	Kthid        string              `json:"kthid"`
 	Cred         webauthn.Credential `json:"-"`
 	Discoverable bool                `json:"discoverable"`
+	// Set if the sign count has gone backwards, which could mean that the passkey was cloned.
+	CloneWarning bool `json:"clone_warning"`
+	// The model of the authenticator, such as "iCloud Keychain" or "YubiKey 5", if known.
+	Authenticator string    `json:"authenticator"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Zero if the passkey has never been used to log in.
+	LastUsedAt time.Time `json:"last_used_at"`
 }
 
 type WebAuthnUser struct {
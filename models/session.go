@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginMethod is how a session was created. It's stored in the database, so
+// existing values must not be changed.
+type LoginMethod string
+
+const (
+	LoginMethodKTH          LoginMethod = "kth"
+	LoginMethodPasskey      LoginMethod = "passkey"
+	LoginMethodEmail        LoginMethod = "email"
+	LoginMethodDev          LoginMethod = "dev"
+	LoginMethodTOTP         LoginMethod = "totp"
+	LoginMethodRecoveryCode LoginMethod = "recovery-code"
+	LoginMethodDevice       LoginMethod = "device"
+	// Set by the CreateImpersonationSession query.
+	LoginMethodImpersonation LoginMethod = "impersonation"
+)
+
+func (m LoginMethod) Description() string {
+	switch m {
+	case LoginMethodKTH:
+		return "KTH"
+	case LoginMethodPasskey:
+		return "Passkey"
+	case LoginMethodEmail:
+		return "Email code"
+	case LoginMethodDev:
+		return "Development login"
+	case LoginMethodTOTP:
+		return "Authenticator app"
+	case LoginMethodRecoveryCode:
+		return "Recovery code"
+	case LoginMethodDevice:
+		return "Approved from another device"
+	case LoginMethodImpersonation:
+		return "Impersonation"
+	default:
+		return "Unknown"
+	}
+}
+
+type Session struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	LoginMethod LoginMethod
+	UserAgent   string
+	IPAddress   string
+	Remembered  bool
+	// Impersonator is the kthid of the admin viewing the site as the user in this session, if any.
+	Impersonator string
+	// Whether this is the session of the request it was listed in.
+	Current bool
+}
+
+type SessionIDCtxKey struct{}
+
+// ImpersonatorCtxKey holds the kthid of the admin impersonating the logged in user, if any.
+type ImpersonatorCtxKey struct{}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+type TOTPStatus struct {
+	Enabled bool
+	// Zero if TOTP is not enabled.
+	EnabledAt time.Time
+}
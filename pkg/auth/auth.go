@@ -2,19 +2,30 @@ package auth
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/datasektionen/sso/pkg/config"
 )
 
 const SessionCookieName string = "_sso_session"
 
-func SessionCookie(sessionID string) *http.Cookie {
+// RememberMeCookieName is set by the "remember this device" checkbox on the login page, so that it
+// applies to all login methods.
+const RememberMeCookieName string = "_sso_remember-me"
+
+func SessionCookie(cfg *config.Cfg, sessionID string, expires time.Time) *http.Cookie {
 	return &http.Cookie{
 		Name:     SessionCookieName,
 		Value:    sessionID,
 		Path:     "/",
+		Expires:  expires,
 		HttpOnly: true,
-		Secure:   !config.Config.Dev,
+		Secure:   !cfg.Dev,
 		SameSite: http.SameSiteLaxMode,
 	}
 }
+
+func WantsToBeRemembered(r *http.Request) bool {
+	c, _ := r.Cookie(RememberMeCookieName)
+	return c != nil && c.Value == "true"
+}
@@ -1,9 +1,19 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
 )
 
 type Cfg struct {
@@ -22,72 +32,363 @@ type Cfg struct {
 	PlsURL                *url.URL
 	HiveURL               *url.URL
 	HiveAPIKey            string
-	SpamURL               *url.URL
-	SpamAPIKey            string
-	RfingerURL            *url.URL
-	RfingerAPIKey         string
-}
-
-var Config Cfg
-
-func init() {
-	Config = Cfg{
-		KTHOIDCIssuerURL:      getURL("KTH_ISSUER_URL", false),
-		KTHOIDCClientID:       os.Getenv("KTH_CLIENT_ID"),
-		KTHOIDCClientSecret:   os.Getenv("KTH_CLIENT_SECRET"),
-		KTHOIDCRPOrigin:       getOrigin("KTH_RP_ORIGIN", false),
-		Origin:                getOrigin("ORIGIN", false),
-		PortExternal:          getInt("PORT_EXTERNAL", 0),
-		PortInternal:          getInt("PORT", 7000),
-		DatabaseURL:           getURL("DATABASE_URL", false),
-		Dev:                   os.Getenv("DEV") == "true",
-		LDAPProxyURL:          getURL("LDAP_PROXY_URL", true),
-		OIDCProviderIssuerURL: getURL("OIDC_PROVIDER_ISSUER_URL", false),
-		OIDCProviderKey:       os.Getenv("OIDC_PROVIDER_KEY"),
-		PlsURL:                getURL("PLS_URL", true),
-		HiveURL:               getURL("HIVE_URL", true),
-		HiveAPIKey:            os.Getenv("HIVE_API_KEY"),
-		SpamURL:               getURL("SPAM_URL", true),
-		SpamAPIKey:            os.Getenv("SPAM_API_KEY"),
-		RfingerURL:            getURL("RFINGER_URL", true),
-		RfingerAPIKey:         os.Getenv("RFINGER_API_KEY"),
-	}
-}
-
-func getURL(envName string, optional bool) *url.URL {
-	envValue, ok := os.LookupEnv(envName)
+	HiveCacheTTL          time.Duration
+	HiveCacheGrace        time.Duration
+	HiveCacheSize         int
+	PermissionsRefresh    time.Duration
+	// A session ends when it hasn't been used for the idle timeout or when
+	// it reaches its max age, whichever comes first. Users can choose to be
+	// remembered, which gives them the longer Remembered* lifetimes.
+	SessionIdleTimeout           time.Duration
+	SessionMaxAge                time.Duration
+	RememberedSessionIdleTimeout time.Duration
+	RememberedSessionMaxAge      time.Duration
+	SpamURL                      *url.URL
+	SpamAPIKey                   string
+	RfingerURL                   *url.URL
+	RfingerAPIKey                string
+	// One of "none", "indirect", "direct" and "enterprise".
+	WebAuthnAttestation string
+	// If not empty, users with any SSO permission can only use passkeys from authenticators with
+	// these AAGUIDs. Requires WebAuthnAttestation to be "direct" or "enterprise" and
+	// WebAuthnMDSPath to be set.
+	WebAuthnAdminAAGUIDs []uuid.UUID
+	// Where the FIDO metadata service's blob is cached. It's downloaded on startup if missing or
+	// out of date.
+	WebAuthnMDSPath string
+	// Either "postgres", which is shared between replicas, or "memory".
+	RateLimitStore string
+	// Per client IP for the login endpoints that take credentials or send emails.
+	RateLimitLogin RateLimit
+	// Per KTH ID for email, TOTP and recovery code logins.
+	RateLimitLoginAccount RateLimit
+	// Per client IP for starting passkey autofill, which every load of the login page does.
+	RateLimitPasskeyAutofill RateLimit
+	// Per client IP for requesting an account.
+	RateLimitRequestAccount RateLimit
+	// Per client IP for the OIDC provider's token endpoint.
+	RateLimitToken RateLimit
+	// If set, an email is sent here when a rate limit is exceeded.
+	RateLimitAlertEmail string
+	// If true, the Content-Security-Policy is only reported on, not enforced.
+	CSPReportOnly bool
+	// If set, browsers report violations of the Content-Security-Policy here.
+	CSPReportURI string
+	// If set, traces are exported here with OTLP over HTTP. Tracing is off otherwise.
+	OTLPTracesEndpoint *url.URL
+}
+
+// RateLimit allows Limit requests per Window. A zero RateLimit allows everything.
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+func (r RateLimit) String() string {
+	if r.Limit <= 0 {
+		return "off"
+	}
+	return strconv.Itoa(r.Limit) + "/" + r.Window.String()
+}
+
+// Load reads the configuration from environment variables.
+//
+// If $CONFIG_FILE is set, it must point to a TOML or YAML file (decided by
+// the extension) with a flat table of the same variables, with lower case
+// names, e.g. `kth_issuer_url = "..."`. Environment variables take precedence
+// over the file.
+//
+// Any variable can instead be given as the path to a file containing the
+// value by appending `_FILE` to its name, e.g. $SPAM_API_KEY_FILE. This is
+// mostly useful for secrets.
+//
+// All problems with the configuration are reported in the returned error,
+// not just the first one.
+func Load() (*Cfg, error) {
+	l := loader{file: map[string]string{}}
+	if path, ok := os.LookupEnv("CONFIG_FILE"); ok {
+		file, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read $CONFIG_FILE: %w", err)
+		}
+		l.file = file
+	}
+
+	cfg := &Cfg{
+		KTHOIDCIssuerURL:             l.url("KTH_ISSUER_URL", false),
+		KTHOIDCClientID:              l.string("KTH_CLIENT_ID"),
+		KTHOIDCClientSecret:          l.string("KTH_CLIENT_SECRET"),
+		KTHOIDCRPOrigin:              l.origin("KTH_RP_ORIGIN", false),
+		Origin:                       l.origin("ORIGIN", false),
+		PortExternal:                 l.int("PORT_EXTERNAL", 0),
+		PortInternal:                 l.int("PORT", 7000),
+		DatabaseURL:                  l.url("DATABASE_URL", false),
+		Dev:                          l.string("DEV") == "true",
+		LDAPProxyURL:                 l.url("LDAP_PROXY_URL", true),
+		OIDCProviderIssuerURL:        l.url("OIDC_PROVIDER_ISSUER_URL", false),
+		OIDCProviderKey:              l.string("OIDC_PROVIDER_KEY"),
+		PlsURL:                       l.url("PLS_URL", true),
+		HiveURL:                      l.url("HIVE_URL", true),
+		HiveAPIKey:                   l.string("HIVE_API_KEY"),
+		HiveCacheTTL:                 l.duration("HIVE_CACHE_TTL", time.Minute),
+		HiveCacheGrace:               l.duration("HIVE_CACHE_GRACE", time.Hour),
+		HiveCacheSize:                l.int("HIVE_CACHE_SIZE", 1024),
+		PermissionsRefresh:           l.duration("PERMISSIONS_REFRESH_INTERVAL", 5*time.Minute),
+		SessionIdleTimeout:           l.duration("SESSION_IDLE_TIMEOUT", 8*time.Hour),
+		SessionMaxAge:                l.duration("SESSION_MAX_AGE", 24*time.Hour),
+		RememberedSessionIdleTimeout: l.duration("REMEMBERED_SESSION_IDLE_TIMEOUT", 30*24*time.Hour),
+		RememberedSessionMaxAge:      l.duration("REMEMBERED_SESSION_MAX_AGE", 90*24*time.Hour),
+		SpamURL:                      l.url("SPAM_URL", true),
+		SpamAPIKey:                   l.string("SPAM_API_KEY"),
+		RfingerURL:                   l.url("RFINGER_URL", true),
+		RfingerAPIKey:                l.string("RFINGER_API_KEY"),
+		WebAuthnAttestation:          l.string("WEBAUTHN_ATTESTATION"),
+		WebAuthnAdminAAGUIDs:         l.uuids("WEBAUTHN_ADMIN_AAGUIDS"),
+		WebAuthnMDSPath:              l.string("WEBAUTHN_MDS_PATH"),
+		RateLimitStore:               l.string("RATE_LIMIT_STORE"),
+		RateLimitLogin:               l.rateLimit("RATE_LIMIT_LOGIN", RateLimit{100, 10 * time.Minute}),
+		RateLimitLoginAccount:        l.rateLimit("RATE_LIMIT_LOGIN_ACCOUNT", RateLimit{10, time.Hour}),
+		RateLimitPasskeyAutofill:     l.rateLimit("RATE_LIMIT_PASSKEY_AUTOFILL", RateLimit{1000, 10 * time.Minute}),
+		RateLimitRequestAccount:      l.rateLimit("RATE_LIMIT_REQUEST_ACCOUNT", RateLimit{5, time.Hour}),
+		RateLimitToken:               l.rateLimit("RATE_LIMIT_TOKEN", RateLimit{120, time.Minute}),
+		RateLimitAlertEmail:          l.string("RATE_LIMIT_ALERT_EMAIL"),
+		CSPReportOnly:                l.string("CSP_REPORT_ONLY") == "true",
+		CSPReportURI:                 l.string("CSP_REPORT_URI"),
+		OTLPTracesEndpoint:           l.url("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", true),
+	}
+
+	switch cfg.WebAuthnAttestation {
+	case "":
+		cfg.WebAuthnAttestation = "none"
+	case "none", "indirect", "direct", "enterprise":
+	default:
+		l.fail("$WEBAUTHN_ATTESTATION must be one of none, indirect, direct and enterprise")
+	}
+	// Without verified attestation, the AAGUID is whatever the client says, or usually just zeroes.
+	if len(cfg.WebAuthnAdminAAGUIDs) > 0 && cfg.WebAuthnAttestation != "direct" && cfg.WebAuthnAttestation != "enterprise" {
+		l.fail("$WEBAUTHN_ADMIN_AAGUIDS requires $WEBAUTHN_ATTESTATION to be direct or enterprise")
+	}
+	// The attestation is verified against the root certificates in the metadata service.
+	if len(cfg.WebAuthnAdminAAGUIDs) > 0 && cfg.WebAuthnMDSPath == "" {
+		l.fail("$WEBAUTHN_ADMIN_AAGUIDS requires $WEBAUTHN_MDS_PATH")
+	}
+
+	switch cfg.RateLimitStore {
+	case "":
+		cfg.RateLimitStore = "postgres"
+	case "postgres", "memory":
+	default:
+		l.fail("$RATE_LIMIT_STORE must be either postgres or memory")
+	}
+
+	if cfg.OIDCProviderIssuerURL != nil && cfg.OIDCProviderIssuerURL.Path != "/op" {
+		l.fail("The path of $OIDC_PROVIDER_ISSUER_URL must be `/op`")
+	}
+	if len(strings.Split(cfg.OIDCProviderKey, ",")) != 2 {
+		l.fail("Expected $OIDC_PROVIDER_KEY to have two comma-separated prime numbers in base 62")
+	}
+	if cfg.SessionIdleTimeout <= 0 || cfg.SessionMaxAge <= 0 || cfg.RememberedSessionIdleTimeout <= 0 || cfg.RememberedSessionMaxAge <= 0 {
+		l.fail("Session lifetimes must be positive")
+	}
+	if cfg.HiveURL != nil && cfg.HiveAPIKey == "" {
+		l.fail("$HIVE_API_KEY must be set when $HIVE_URL is")
+	}
+	if cfg.RfingerURL != nil && cfg.RfingerAPIKey == "" {
+		l.fail("$RFINGER_API_KEY must be set when $RFINGER_URL is")
+	}
+
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying the configuration, for the places
+// that have no other way to get it, like templates.
+func NewContext(ctx context.Context, cfg *Cfg) context.Context {
+	return context.WithValue(ctx, ctxKey{}, cfg)
+}
+
+// FromContext returns the configuration stored by NewContext. If there is
+// none, a zero configuration is returned.
+func FromContext(ctx context.Context) *Cfg {
+	if cfg, ok := ctx.Value(ctxKey{}).(*Cfg); ok {
+		return cfg
+	}
+	return &Cfg{}
+}
+
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("Unknown config file extension %q, expected .toml, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("Expected %s to be a single value", key)
+		}
+		file[strings.ToUpper(key)] = fmt.Sprint(value)
+	}
+	return file, nil
+}
+
+// loader looks up variables and collects everything wrong with them.
+type loader struct {
+	file map[string]string
+	errs []error
+}
+
+func (l *loader) fail(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+func (l *loader) lookup(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if path, ok := os.LookupEnv(name + "_FILE"); ok {
+		return l.readValueFile(name, path)
+	}
+	if value, ok := l.file[name]; ok {
+		return value, true
+	}
+	if path, ok := l.file[name+"_FILE"]; ok {
+		return l.readValueFile(name, path)
+	}
+	return "", false
+}
+
+func (l *loader) readValueFile(name, path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.fail("Could not read $%s_FILE: %w", name, err)
+		return "", false
+	}
+	return strings.TrimRight(string(data), "\r\n"), true
+}
+
+func (l *loader) string(name string) string {
+	value, _ := l.lookup(name)
+	return value
+}
+
+func (l *loader) url(name string, optional bool) *url.URL {
+	value, ok := l.lookup(name)
 	if !ok {
 		if !optional {
-			panic("Missing $" + envName)
+			l.fail("Missing $%s", name)
 		}
 		return nil
 	}
-	u, err := url.Parse(envValue)
+	u, err := url.Parse(value)
 	if err != nil {
-		panic(err)
+		l.fail("Invalid URL in $%s: %w", name, err)
+		return nil
 	}
 	return u
 }
 
-func getOrigin(envName string, optional bool) *url.URL {
-	u := getURL(envName, optional)
+func (l *loader) origin(name string, optional bool) *url.URL {
+	u := l.url(name, optional)
+	if u == nil {
+		return nil
+	}
 	withoutSchemeHost := *u
 	withoutSchemeHost.Scheme = ""
 	withoutSchemeHost.Host = ""
 	if withoutSchemeHost != (url.URL{}) {
-		panic("Only scheme and host (=hostname and port) may be set on $" + envName)
+		l.fail("Only scheme and host (=hostname and port) may be set on $%s", name)
+		return nil
 	}
 	return u
 }
 
-func getInt(envName string, defaultValue int) int {
-	s, ok := os.LookupEnv(envName)
+func (l *loader) int(name string, defaultValue int) int {
+	s, ok := l.lookup(name)
 	if !ok {
 		return defaultValue
 	}
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		panic(err)
+		l.fail("Invalid integer in $%s: %w", name, err)
+		return defaultValue
 	}
 	return i
 }
+
+func (l *loader) duration(name string, defaultValue time.Duration) time.Duration {
+	s, ok := l.lookup(name)
+	if !ok {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		l.fail("Invalid duration in $%s: %w", name, err)
+		return defaultValue
+	}
+	return d
+}
+
+// uuids reads a comma-separated list of UUIDs.
+func (l *loader) uuids(name string) []uuid.UUID {
+	s, ok := l.lookup(name)
+	if !ok {
+		return nil
+	}
+	var ids []uuid.UUID
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			l.fail("Invalid UUID in $%s: %w", name, err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// rateLimit reads a limit on the form `<requests>/<window>`, e.g. `10/1h`, or `off`.
+func (l *loader) rateLimit(name string, defaultValue RateLimit) RateLimit {
+	s, ok := l.lookup(name)
+	if !ok {
+		return defaultValue
+	}
+	if s == "off" {
+		return RateLimit{}
+	}
+	limit, window, ok := strings.Cut(s, "/")
+	if !ok {
+		l.fail("Expected $%s to be on the form <requests>/<window>, e.g. 10/1h, or off", name)
+		return defaultValue
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		l.fail("Invalid number of requests in $%s", name)
+		return defaultValue
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		l.fail("Invalid window in $%s", name)
+		return defaultValue
+	}
+	return RateLimit{Limit: n, Window: d}
+}
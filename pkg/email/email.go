@@ -12,17 +12,32 @@ import (
 	"github.com/datasektionen/sso/pkg/config"
 )
 
-func Send(ctx context.Context, recipient, subject, contents string) error {
-	slog.Info("Email sent", "recipient", recipient, "subject", subject)
+type Client struct {
+	cfg  *config.Cfg
+	http *http.Client
+}
+
+// NewClient creates a client that sends emails through the spam instance at
+// cfg.SpamURL. If that is nil, emails are logged instead of sent. If
+// httpClient is nil, http.DefaultClient is used.
+func NewClient(cfg *config.Cfg, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, http: httpClient}
+}
 
-	if config.Config.SpamURL == nil {
-		slog.Info("Email not sent since no url was provided", "contents", contents)
+func (c *Client) Send(ctx context.Context, recipient, subject, contents string) error {
+	slog.InfoContext(ctx, "Email sent", "recipient", recipient, "subject", subject)
+
+	if c.cfg.SpamURL == nil {
+		slog.InfoContext(ctx, "Email not sent since no url was provided", "contents", contents)
 		return nil
 	}
 
 	var body bytes.Buffer
 	if err := json.NewEncoder(&body).Encode(map[string]any{
-		"key": config.Config.SpamAPIKey,
+		"key": c.cfg.SpamAPIKey,
 		"from": map[string]any{
 			"name":    "Datasektionen SSO",
 			"address": "no-reply@datasektionen.se",
@@ -34,7 +49,12 @@ func Send(ctx context.Context, recipient, subject, contents string) error {
 	}); err != nil {
 		return err
 	}
-	resp, err := http.Post(config.Config.SpamURL.String()+"/api/legacy/sendmail", "application/json", &body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.SpamURL.String()+"/api/legacy/sendmail", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return err
 	}
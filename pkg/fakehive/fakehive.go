@@ -0,0 +1,115 @@
+// Package fakehive contains a minimal in-memory stand-in for Hive, serving
+// only the endpoints SSO uses. It is meant for local development and tests,
+// where it makes it possible to log in as users with specific permission
+// sets.
+package fakehive
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type Permission struct {
+	ID    string  `json:"id"`
+	Scope *string `json:"scope"`
+}
+
+// Fixture maps kthid -> system id -> permissions. E.g.:
+//
+//	{ "turetek": { "sso": [ { "id": "read-members" }, { "id": "write-oidc-clients", "scope": "*" } ] } }
+//
+// Users or systems that aren't present have no permissions.
+type Fixture map[string]map[string][]Permission
+
+type Server struct {
+	mu      sync.Mutex
+	fixture Fixture
+	load    func() (Fixture, error)
+	mux     *http.ServeMux
+}
+
+var _ http.Handler = &Server{}
+
+// New returns a fake Hive serving the given permissions. They can be changed
+// later using Set.
+func New(fixture Fixture) *Server {
+	s := &Server{fixture: fixture}
+	s.init()
+	return s
+}
+
+// FromFile returns a fake Hive serving the permissions in the given JSON
+// file. The file is read again on every request, so it can be edited without
+// restarting anything.
+func FromFile(path string) *Server {
+	s := &Server{load: func() (Fixture, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var fixture Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, err
+		}
+		return fixture, nil
+	}}
+	s.init()
+	return s
+}
+
+func (s *Server) init() {
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /api/v1/user/{kthid}/permissions", s.userPermissions)
+}
+
+// Set replaces the permissions for the given user in the given system.
+func (s *Server) Set(kthid, system string, perms ...Permission) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixture == nil {
+		s.fixture = make(Fixture)
+	}
+	if s.fixture[kthid] == nil {
+		s.fixture[kthid] = make(map[string][]Permission)
+	}
+	s.fixture[kthid][system] = perms
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "Missing API key", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) userPermissions(w http.ResponseWriter, r *http.Request) {
+	system := r.Header.Get("X-Hive-Impersonate-System")
+	if system == "" {
+		http.Error(w, "Missing X-Hive-Impersonate-System", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if s.load != nil {
+		fixture, err := s.load()
+		if err != nil {
+			s.mu.Unlock()
+			slog.ErrorContext(r.Context(), "Could not load fake hive fixture", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.fixture = fixture
+	}
+	perms := s.fixture[r.PathValue("kthid")][system]
+	s.mu.Unlock()
+
+	if perms == nil {
+		perms = []Permission{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(perms)
+}
@@ -0,0 +1,112 @@
+package hive
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type cacheKey struct {
+	kthid  string
+	system string
+}
+
+type cacheEntry struct {
+	key        cacheKey
+	perms      []RawPermission
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// permissionCache is a bounded LRU cache of permissions fetched from Hive.
+// Entries younger than the TTL are served as is. Entries older than that but
+// still within the grace period are served while being refreshed in the
+// background, so a slow or unavailable Hive doesn't stall logins. Entries
+// older than TTL + grace are treated as missing.
+type permissionCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	// Most recently used at the front
+	lru *list.List
+}
+
+// GetCachedRawPermissionsInSystemForUser is like
+// GetRawPermissionsInSystemForUser, but goes through a cache. See
+// permissionCache for details.
+func (c *Client) GetCachedRawPermissionsInSystemForUser(ctx context.Context, kthid string, system string) ([]RawPermission, error) {
+	key := cacheKey{kthid, system}
+	ttl := c.cfg.HiveCacheTTL
+	grace := c.cfg.HiveCacheGrace
+
+	c.cache.mu.Lock()
+	if el, ok := c.cache.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		age := time.Since(entry.fetchedAt)
+		if age < ttl {
+			c.cache.lru.MoveToFront(el)
+			c.cache.mu.Unlock()
+			return entry.perms, nil
+		}
+		if age < ttl+grace {
+			c.cache.lru.MoveToFront(el)
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.refresh(context.WithoutCancel(ctx), key)
+			}
+			c.cache.mu.Unlock()
+			return entry.perms, nil
+		}
+	}
+	c.cache.mu.Unlock()
+
+	perms, err := c.GetRawPermissionsInSystemForUser(ctx, kthid, system)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.store(key, perms, c.cfg.HiveCacheSize)
+	return perms, nil
+}
+
+func (c *Client) refresh(ctx context.Context, key cacheKey) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	perms, err := c.GetRawPermissionsInSystemForUser(ctx, key.kthid, key.system)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not refresh permissions from hive, serving stale permissions", "kthid", key.kthid, "system", key.system, "error", err)
+		c.cache.mu.Lock()
+		if el, ok := c.cache.entries[key]; ok {
+			el.Value.(*cacheEntry).refreshing = false
+		}
+		c.cache.mu.Unlock()
+		return
+	}
+	c.cache.store(key, perms, c.cfg.HiveCacheSize)
+}
+
+func (c *permissionCache) store(key cacheKey, perms []RawPermission, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.perms = perms
+		entry.fetchedAt = time.Now()
+		entry.refreshing = false
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:       key,
+		perms:     perms,
+		fetchedAt: time.Now(),
+	})
+	for c.lru.Len() > max(size, 1) {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
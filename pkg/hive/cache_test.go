@@ -0,0 +1,155 @@
+package hive
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/fakehive"
+)
+
+type testHive struct {
+	*fakehive.Server
+	requests atomic.Int32
+	down     atomic.Bool
+}
+
+func (h *testHive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.requests.Add(1)
+	if h.down.Load() {
+		http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	h.Server.ServeHTTP(w, r)
+}
+
+func newTestClient(t *testing.T, size int) (*Client, *testHive) {
+	t.Helper()
+	hive := &testHive{Server: fakehive.New(nil)}
+	server := httptest.NewServer(hive)
+	t.Cleanup(server.Close)
+	hiveURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(&config.Cfg{
+		HiveURL:        hiveURL,
+		HiveAPIKey:     "hive-key",
+		HiveCacheTTL:   time.Minute,
+		HiveCacheGrace: time.Hour,
+		HiveCacheSize:  size,
+	}, nil), hive
+}
+
+// age makes the cached entry look like it was fetched d ago.
+func (c *Client) age(kthid, system string, d time.Duration) {
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+	c.cache.entries[cacheKey{kthid, system}].Value.(*cacheEntry).fetchedAt = time.Now().Add(-d)
+}
+
+// waitForRefresh waits until no background refresh of the entry is running.
+func (c *Client) waitForRefresh(t *testing.T, kthid, system string) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		c.cache.mu.Lock()
+		refreshing := c.cache.entries[cacheKey{kthid, system}].Value.(*cacheEntry).refreshing
+		c.cache.mu.Unlock()
+		if !refreshing {
+			return
+		}
+	}
+	t.Fatal("The refresh never finished")
+}
+
+func getIDs(t *testing.T, c *Client, kthid string) []string {
+	t.Helper()
+	perms, err := c.GetCachedRawPermissionsInSystemForUser(context.Background(), kthid, "sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(perms))
+	for i, perm := range perms {
+		ids[i] = perm.ID
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected permissions %v, got %v", want, got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("Expected permissions %v, got %v", want, got)
+		}
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	c, hive := newTestClient(t, 10)
+	hive.Set("turetek", "sso", fakehive.Permission{ID: "read-members"})
+
+	expectIDs(t, getIDs(t, c, "turetek"), "read-members")
+	hive.Set("turetek", "sso", fakehive.Permission{ID: "manage-members"})
+	expectIDs(t, getIDs(t, c, "turetek"), "read-members")
+	if n := hive.requests.Load(); n != 1 {
+		t.Fatalf("Expected fresh permissions to be cached, got %d requests", n)
+	}
+
+	// Within the grace period, the stale permissions are served while they're refreshed.
+	c.age("turetek", "sso", 2*time.Minute)
+	expectIDs(t, getIDs(t, c, "turetek"), "read-members")
+	c.waitForRefresh(t, "turetek", "sso")
+	expectIDs(t, getIDs(t, c, "turetek"), "manage-members")
+	if n := hive.requests.Load(); n != 2 {
+		t.Fatalf("Expected a single refresh, got %d requests", n)
+	}
+
+	// After the grace period, they're fetched before responding.
+	hive.Set("turetek", "sso", fakehive.Permission{ID: "read-audit-log"})
+	c.age("turetek", "sso", 2*time.Hour)
+	expectIDs(t, getIDs(t, c, "turetek"), "read-audit-log")
+}
+
+func TestCacheHiveDown(t *testing.T) {
+	c, hive := newTestClient(t, 10)
+	hive.Set("turetek", "sso", fakehive.Permission{ID: "read-members"})
+	expectIDs(t, getIDs(t, c, "turetek"), "read-members")
+
+	hive.down.Store(true)
+	c.age("turetek", "sso", 2*time.Minute)
+	expectIDs(t, getIDs(t, c, "turetek"), "read-members")
+	c.waitForRefresh(t, "turetek", "sso")
+	// The failed refresh is retried on the next lookup.
+	expectIDs(t, getIDs(t, c, "turetek"), "read-members")
+	c.waitForRefresh(t, "turetek", "sso")
+	if n := hive.requests.Load(); n != 3 {
+		t.Fatalf("Expected the refresh to be retried, got %d requests", n)
+	}
+
+	c.age("turetek", "sso", 2*time.Hour)
+	if _, err := c.GetCachedRawPermissionsInSystemForUser(context.Background(), "turetek", "sso"); err == nil {
+		t.Fatal("Expected an error once the grace period is over")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, hive := newTestClient(t, 2)
+	for _, kthid := range []string{"a", "b", "a", "c"} {
+		getIDs(t, c, kthid)
+	}
+	// b was the least recently used when c was added.
+	if _, ok := c.cache.entries[cacheKey{"b", "sso"}]; ok || len(c.cache.entries) != 2 {
+		t.Fatalf("Expected b to be evicted, got %v", c.cache.entries)
+	}
+	if n := hive.requests.Load(); n != 3 {
+		t.Fatalf("Expected 3 requests, got %d", n)
+	}
+}
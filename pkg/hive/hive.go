@@ -1,6 +1,7 @@
 package hive
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -47,16 +48,58 @@ type Permissions struct {
 	ManageAccountRequests    bool             `hive:"manage-account-requests"`
 	ReadNameChangeRequests   bool             `hive:"read-name-change-requests"`
 	ManageNameChangeRequests bool             `hive:"manage-name-change-requests"`
+	ReadAuditLog             bool             `hive:"read-audit-log"`
+	ImpersonateUsers         bool             `hive:"impersonate-users"`
+}
+
+// Any reports whether any permission is set, i.e. if the user is some kind of admin.
+func (p Permissions) Any() bool {
+	value := reflect.ValueOf(p)
+	for i := range value.NumField() {
+		switch field := value.Field(i).Interface().(type) {
+		case bool:
+			if field {
+				return true
+			}
+		case PermissionScopes:
+			if field.Exists() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type PermissionsCtxKey struct{}
 
-func GetSSOPermissions(ctx context.Context, kthid string) (Permissions, error) {
-	if config.Config.Dev && config.Config.HiveURL == nil {
-		return Permissions{true, true, true, true, PermissionScopes{Scopes: []string{"*"}}, true, true, true, true, true, true}, nil
+type Client struct {
+	cfg   *config.Cfg
+	http  *http.Client
+	cache permissionCache
+}
+
+// NewClient creates a client for the Hive instance at cfg.HiveURL. If
+// httpClient is nil, http.DefaultClient is used.
+func NewClient(cfg *config.Cfg, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		cfg:  cfg,
+		http: httpClient,
+		cache: permissionCache{
+			entries: make(map[cacheKey]*list.Element),
+			lru:     list.New(),
+		},
+	}
+}
+
+func (c *Client) GetSSOPermissions(ctx context.Context, kthid string) (Permissions, error) {
+	if c.cfg.Dev && c.cfg.HiveURL == nil {
+		return Permissions{true, true, true, true, PermissionScopes{Scopes: []string{"*"}}, true, true, true, true, true, true, true, true}, nil
 	}
 
-	rawPerms, err := GetRawPermissionsInSystemForUser(ctx, kthid, "sso")
+	rawPerms, err := c.GetRawPermissionsInSystemForUser(ctx, kthid, "sso")
 	if err != nil {
 		return Permissions{}, err
 	}
@@ -100,24 +143,25 @@ type RawPermission struct {
 
 // Result follows the format described in the hive documentation. E.g.:
 // [ { "id": "attest", "scope": "*" }, { "id": "view-logs", "scope": null }, { "id": "write", "scope": "/central/flag.txt" } ]
-func GetRawPermissionsInSystemForUser(ctx context.Context, kthid string, system string) ([]RawPermission, error) {
-	if config.Config.HiveURL == nil || config.Config.HiveAPIKey == "" {
+func (c *Client) GetRawPermissionsInSystemForUser(ctx context.Context, kthid string, system string) ([]RawPermission, error) {
+	if c.cfg.HiveURL == nil || c.cfg.HiveAPIKey == "" {
 		return nil, fmt.Errorf("URL or API key to hive missing in env variables, so cannot fetch permissions from hive")
 	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
 		"%s/api/v1/user/%s/permissions",
-		config.Config.HiveURL.String(),
+		c.cfg.HiveURL.String(),
 		url.PathEscape(kthid),
 	), nil)
-	req.Header.Set("Authorization", "Bearer "+config.Config.HiveAPIKey)
-	req.Header.Set("X-Hive-Impersonate-System", system)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	req.Header.Set("Authorization", "Bearer "+c.cfg.HiveAPIKey)
+	req.Header.Set("X-Hive-Impersonate-System", system)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Unexpected status code from hive: %d", resp.StatusCode)
 	}
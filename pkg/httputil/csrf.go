@@ -0,0 +1,100 @@
+package httputil
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/datasektionen/sso/pkg/config"
+)
+
+// CSRF protection uses the double-submit pattern: every browser gets a random token in a cookie,
+// which pages include in a header (or form field for plain forms) when making requests that change
+// something. Another site can make the browser send the cookie, but it can't read it, so it can't
+// put it in the header.
+const (
+	CSRFCookie = "_sso_csrf"
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "_csrf"
+)
+
+type csrfCtxKey struct{}
+
+// CSRFToken returns the token that must be sent back with state-changing requests, for use in
+// templates. It's only set for requests that went through a handler registered on a CSRFMux.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfCtxKey{}).(string)
+	return token
+}
+
+// CSRFMux registers handlers on a http.ServeMux, giving all of them a CSRF token and rejecting
+// requests to the ones for methods other than GET that don't send it back or that come from
+// another origin.
+type CSRFMux struct {
+	mux *http.ServeMux
+	cfg *config.Cfg
+}
+
+func NewCSRFMux(mux *http.ServeMux, cfg *config.Cfg) CSRFMux {
+	return CSRFMux{mux: mux, cfg: cfg}
+}
+
+func (m CSRFMux) Handle(pattern string, h http.Handler) {
+	method, _, hasMethod := strings.Cut(pattern, " ")
+	check := hasMethod && method != http.MethodGet && method != http.MethodHead
+	m.mux.Handle(pattern, csrf(m.cfg, h, check))
+}
+
+func (m CSRFMux) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(f))
+}
+
+func csrf(cfg *config.Cfg, h http.Handler, check bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if c, _ := r.Cookie(CSRFCookie); c != nil && c.Value != "" {
+			token = c.Value
+		} else if check {
+			Respond(Forbidden("Missing CSRF token, please reload the page"), w, r)
+			return
+		} else {
+			token = rand.Text()
+			http.SetCookie(w, &http.Cookie{
+				Name:     CSRFCookie,
+				Value:    token,
+				Path:     "/",
+				Secure:   !cfg.Dev,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if check {
+			if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, cfg.Origin) {
+				Respond(Forbidden("Cross-origin request rejected"), w, r)
+				return
+			}
+			sent := r.Header.Get(CSRFHeader)
+			if sent == "" {
+				sent = r.FormValue(CSRFField)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				Respond(Forbidden("Invalid CSRF token, please reload the page"), w, r)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfCtxKey{}, token)))
+	})
+}
+
+func sameOrigin(origin string, expected *url.URL) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == expected.Scheme && u.Host == expected.Host
+}
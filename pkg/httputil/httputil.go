@@ -4,9 +4,21 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/a-h/templ"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/datasektionen/sso/pkg/metrics"
+	"github.com/datasektionen/sso/pkg/tracing"
 )
 
 type ToResponse any
@@ -25,9 +37,14 @@ func Respond(resp ToResponse, w http.ResponseWriter, r *http.Request) {
 		err := resp
 		var httpErr HttpError
 		if errors.As(err, &httpErr) {
+			metrics.ResponseErrors.WithLabelValues(strconv.Itoa(httpErr.StatusCode)).Inc()
 			httpErr.ServeHTTP(w, r)
 		} else {
-			slog.Error("Error serving request", "path", r.URL.Path, "error", err)
+			metrics.ResponseErrors.WithLabelValues("500").Inc()
+			span := trace.SpanFromContext(r.Context())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			slog.ErrorContext(r.Context(), "Error serving request", "path", r.URL.Path, "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte("Internal server error"))
 		}
@@ -41,7 +58,7 @@ func Respond(resp ToResponse, w http.ResponseWriter, r *http.Request) {
 		j := resp.any
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(j); err != nil {
-			slog.Error("Error writing json", "value", j)
+			slog.ErrorContext(r.Context(), "Error writing json", "value", j)
 		}
 	case redirect:
 		if r.Header.Get("HX-Request") == "true" {
@@ -50,14 +67,20 @@ func Respond(resp ToResponse, w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, resp.url, http.StatusSeeOther)
 		}
 	default:
-		slog.Error("Got invalid response type when serving request", "url", r.URL.String(), "response", resp)
+		slog.ErrorContext(r.Context(), "Got invalid response type when serving request", "url", r.URL.String(), "response", resp)
 	}
 }
 
 func Route[S interface {
 	WithSession(r *http.Request) (*http.Request, error)
 }](s S, f func(s S, w http.ResponseWriter, r *http.Request) ToResponse) http.Handler {
+	// E.g. `handlers.index`, so that spans show which handler a request was routed to.
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	name = name[strings.LastIndexByte(name, '/')+1:]
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer.Start(r.Context(), name)
+		defer span.End()
+		r = r.WithContext(ctx)
 		r2, err := s.WithSession(r)
 		if err != nil {
 			Respond(err, w, r)
@@ -75,6 +98,8 @@ func JSON(val any) jsonValue {
 type HttpError struct {
 	Message    string
 	StatusCode int
+	// Sent in the Retry-After header if set.
+	RetryAfter time.Duration
 }
 
 func (h HttpError) Error() string {
@@ -86,6 +111,9 @@ func (h HttpError) Error() string {
 }
 
 func (h HttpError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.RetryAfter.Seconds()))))
+	}
 	w.WriteHeader(h.StatusCode)
 	_, _ = w.Write([]byte(h.Message))
 }
@@ -106,6 +134,14 @@ func NotFound() error {
 	return HttpError{Message: "Not Found", StatusCode: http.StatusNotFound}
 }
 
+func TooManyRequests(retryAfter time.Duration) error {
+	return HttpError{
+		Message:    "Too many requests, please try again later",
+		StatusCode: http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+	}
+}
+
 type redirect struct {
 	url string
 }
@@ -115,3 +151,23 @@ type redirect struct {
 func Redirect(url string) ToResponse {
 	return redirect{url}
 }
+
+// ClientIP returns the address of the client. We're always behind a reverse proxy in production,
+// which appends the address it got the request from to X-Forwarded-For, so the last entry there is
+// the only one that can be trusted.
+func ClientIP(r *http.Request) string {
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		last := xff[len(xff)-1]
+		if i := strings.LastIndexByte(last, ','); i != -1 {
+			last = last[i+1:]
+		}
+		if ip := strings.TrimSpace(last); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
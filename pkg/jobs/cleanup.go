@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/pkg/config"
+)
+
+// Cleanup returns jobs that delete rows that have expired and can no longer
+// be used for anything.
+func Cleanup(cfg *config.Cfg) []Job {
+	return []Job{
+		cleanupJob("cleanup-sessions", func(db *database.Queries, ctx context.Context) (int64, error) {
+			return db.DeleteExpiredSessions(ctx, database.DeleteExpiredSessionsParams{
+				RememberedIdleTimeout: int32(cfg.RememberedSessionIdleTimeout.Seconds()),
+				IdleTimeout:           int32(cfg.SessionIdleTimeout.Seconds()),
+				RememberedMaxAge:      int32(cfg.RememberedSessionMaxAge.Seconds()),
+				MaxAge:                int32(cfg.SessionMaxAge.Seconds()),
+			})
+		}),
+		cleanupJob("cleanup-email-logins", (*database.Queries).DeleteExpiredEmailLogins),
+		cleanupJob("cleanup-email-change-requests", (*database.Queries).DeleteExpiredEmailChangeRequests),
+		cleanupJob("cleanup-webauthn-session-data", (*database.Queries).DeleteExpiredWebAuthnSessionData),
+		cleanupJob("cleanup-device-logins", (*database.Queries).DeleteExpiredDeviceLogins),
+		cleanupJob("cleanup-legacyapi-tokens", (*database.Queries).DeleteExpiredTokens),
+		cleanupJob("cleanup-invites", (*database.Queries).DeleteExpiredInvites),
+		cleanupJob("cleanup-rate-limits", (*database.Queries).DeleteExpiredRateLimits),
+	}
+}
+
+func cleanupJob(name string, deleteExpired func(*database.Queries, context.Context) (int64, error)) Job {
+	return Job{
+		Name:     name,
+		Interval: time.Hour,
+		Run: func(ctx context.Context, db *database.Queries) error {
+			n, err := deleteExpired(db, ctx)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				slog.InfoContext(ctx, "Deleted expired rows", "job", name, "count", n)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,160 @@
+// Package jobs runs periodic background tasks.
+//
+// Every replica of the web process runs a Scheduler, but each run of a job
+// happens in a transaction holding a Postgres advisory lock for that job, and
+// the start of the last run is stored in the `job_runs` table, so a job is
+// only run by one replica once per interval.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/datasektionen/sso/database"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Run is called within a transaction, which is rolled back if an error
+	// is returned.
+	Run func(ctx context.Context, db *database.Queries) error
+}
+
+type Scheduler struct {
+	db   *database.Queries
+	jobs []Job
+}
+
+func NewScheduler(db *database.Queries, jobs ...Job) *Scheduler {
+	return &Scheduler{db: db, jobs: jobs}
+}
+
+var ErrNoSuchJob = errors.New("No such job")
+var ErrLocked = errors.New("The job is currently running elsewhere")
+
+// Start runs all jobs when they're due until the context is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	// Check often enough that another replica takes over soon if the one
+	// that usually runs the job goes away, and spread the checks out a bit
+	// so all replicas don't race for the lock at once.
+	check := min(job.Interval, time.Minute)
+	wait := time.Duration(rand.Int64N(int64(check)))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = check
+		if _, err := s.run(ctx, job, false); err != nil {
+			slog.ErrorContext(ctx, "Job failed", "job", job.Name, "error", err)
+		}
+	}
+}
+
+// RunNow runs the job with the given name right away, even if it's not due.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	for _, job := range s.jobs {
+		if job.Name != name {
+			continue
+		}
+		ran, err := s.run(ctx, job, true)
+		if err != nil {
+			return err
+		}
+		if !ran {
+			return ErrLocked
+		}
+		return nil
+	}
+	return ErrNoSuchJob
+}
+
+// run runs the job if no other replica is running it and, unless forced, it
+// hasn't been run in the last interval. Returns whether it was run.
+func (s *Scheduler) run(ctx context.Context, job Job, force bool) (bool, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if locked, err := tx.TryJobLock(ctx, job.Name); err != nil || !locked {
+		return false, err
+	}
+	if !force {
+		recently, err := tx.JobRanRecently(ctx, database.JobRanRecentlyParams{
+			Name:            job.Name,
+			IntervalSeconds: int32(job.Interval.Seconds()),
+		})
+		if err != nil || recently {
+			return false, err
+		}
+	}
+
+	start := time.Now()
+	if err := job.Run(ctx, tx); err != nil {
+		_ = tx.Rollback(ctx)
+		// Recorded outside of the transaction, since that's rolled back.
+		if recordErr := s.db.RecordJobRun(ctx, database.RecordJobRunParams{
+			Name:            job.Name,
+			DurationSeconds: time.Since(start).Seconds(),
+			Error:           err.Error(),
+		}); recordErr != nil {
+			slog.ErrorContext(ctx, "Could not record failed job run", "job", job.Name, "error", recordErr)
+		}
+		return true, err
+	}
+	if err := tx.RecordJobRun(ctx, database.RecordJobRunParams{
+		Name:            job.Name,
+		DurationSeconds: time.Since(start).Seconds(),
+	}); err != nil {
+		return true, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+type Status struct {
+	Name     string
+	Interval time.Duration
+	// The remaining fields are zero if the job has never been run.
+	LastStartedAt  time.Time
+	LastFinishedAt time.Time
+	LastError      string
+}
+
+// Status returns the registered jobs along with how their last runs went.
+func (s *Scheduler) Status(ctx context.Context) ([]Status, error) {
+	runs, err := s.db.ListJobRuns(ctx)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+	byName := make(map[string]database.JobRun, len(runs))
+	for _, run := range runs {
+		byName[run.Name] = run
+	}
+	statuses := make([]Status, len(s.jobs))
+	for i, job := range s.jobs {
+		run := byName[job.Name]
+		statuses[i] = Status{
+			Name:           job.Name,
+			Interval:       job.Interval,
+			LastStartedAt:  run.StartedAt.Time,
+			LastFinishedAt: run.FinishedAt.Time,
+			LastError:      run.Error,
+		}
+	}
+	return statuses, nil
+}
@@ -18,8 +18,23 @@ type Person struct {
 	FamilyName string `json:"family_name"`
 }
 
-func Lookup(ctx context.Context, kthid string) (*Person, error) {
-	if config.Config.LDAPProxyURL == nil {
+type Client struct {
+	cfg  *config.Cfg
+	http *http.Client
+}
+
+// NewClient creates a client for the ldap proxy at cfg.LDAPProxyURL. If that
+// is nil, lookups return made up people instead. If httpClient is nil,
+// http.DefaultClient is used.
+func NewClient(cfg *config.Cfg, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, http: httpClient}
+}
+
+func (c *Client) Lookup(ctx context.Context, kthid string) (*Person, error) {
+	if c.cfg.LDAPProxyURL == nil {
 		fakeName := strings.ToUpper(kthid[:1]) + kthid[1:]
 		return &Person{
 			KTHID:      kthid,
@@ -29,10 +44,15 @@ func Lookup(ctx context.Context, kthid string) (*Person, error) {
 		}, nil
 	}
 
-	resp, err := http.Get(config.Config.LDAPProxyURL.String() + "/user?kthid=" + url.QueryEscape(kthid))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.LDAPProxyURL.String()+"/user?kthid="+url.QueryEscape(kthid), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusNotFound {
 		return nil, nil
 	}
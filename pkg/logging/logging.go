@@ -0,0 +1,132 @@
+// Package logging ties log records to the request they were logged during.
+//
+// Each request gets an ID, taken from the X-Request-ID header if a proxy in front of us has set
+// one. It's sent back in the same header and added to everything logged with the request's
+// context, so that all lines about a request can be found from the access log line.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/datasektionen/sso/pkg/httputil"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestInfo struct {
+	id string
+	// Filled in while the request is handled.
+	kthid  string
+	client string
+}
+
+type requestInfoCtxKey struct{}
+
+// Middleware assigns the request an ID. It must wrap all other middleware, so that they too can
+// log with the ID.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestInfoCtxKey{}, &requestInfo{id: id})
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID keeps clients from putting anything they like in our logs.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns the ID of the request that ctx belongs to, or "" outside of a request.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoCtxKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// SetKTHID records who the request was made by, for the access log.
+func SetKTHID(ctx context.Context, kthid string) {
+	if info, ok := ctx.Value(requestInfoCtxKey{}).(*requestInfo); ok {
+		info.kthid = kthid
+	}
+}
+
+// SetClient records which OIDC client the request was made by, for the access log.
+func SetClient(ctx context.Context, clientID string) {
+	if info, ok := ctx.Value(requestInfoCtxKey{}).(*requestInfo); ok {
+		info.client = clientID
+	}
+}
+
+// AccessLog logs a line for each request once it has been handled. It must be given the same
+// *http.Request as the mux, since that's where the mux puts the pattern of the route.
+func AccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := httpsnoop.CaptureMetrics(h, w, r)
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", r.Pattern),
+			slog.Int("status", m.Code),
+			slog.Duration("duration", m.Duration),
+			slog.Int64("bytes", m.Written),
+			slog.String("ip", httputil.ClientIP(r)),
+		}
+		if info, ok := r.Context().Value(requestInfoCtxKey{}).(*requestInfo); ok {
+			if info.kthid != "" {
+				attrs = append(attrs, slog.String("kthid", info.kthid))
+			}
+			if info.client != "" {
+				attrs = append(attrs, slog.String("client", info.client))
+			}
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "Request", attrs...)
+	})
+}
+
+// Handler adds the request ID, and the trace ID if the request is traced, to records logged with
+// a context from a request.
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(h slog.Handler) Handler {
+	return Handler{h}
+}
+
+func (h Handler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h Handler) WithGroup(name string) slog.Handler {
+	return Handler{h.Handler.WithGroup(name)}
+}
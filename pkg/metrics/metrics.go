@@ -0,0 +1,154 @@
+// Package metrics defines the Prometheus metrics exported on the internal server's /metrics and
+// the middleware that records most of them.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/datasektionen/sso/models"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sso_http_request_duration_seconds",
+		Help:    "Time taken to respond to HTTP requests, by route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	// ResponseErrors counts errors given to httputil.Respond, by the status code they result in.
+	ResponseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sso_response_errors_total",
+		Help: "Errors returned by handlers, by resulting status code.",
+	}, []string{"code"})
+
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sso_logins_total",
+		Help: "Login attempts, by method and outcome (success, failure or error).",
+	}, []string{"method", "outcome"})
+
+	// OIDCLogins counts users sent back to OIDC clients, by whether they were let through.
+	OIDCLogins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sso_oidc_logins_total",
+		Help: "Users sent back to OIDC clients after logging in, by client and outcome.",
+	}, []string{"client", "outcome"})
+
+	// OIDCTokens counts requests to the token endpoint from known clients.
+	OIDCTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sso_oidc_tokens_total",
+		Help: "Token requests from OIDC clients, by client and outcome.",
+	}, []string{"client", "outcome"})
+
+	upstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sso_upstream_request_duration_seconds",
+		Help:    "Time taken by requests to upstream services, by status code or `error`.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream", "method", "code"})
+)
+
+// LoginSucceeded counts a successful login.
+func LoginSucceeded(method models.LoginMethod) {
+	logins.WithLabelValues(string(method), "success").Inc()
+}
+
+// LoginAttempts counts responses from h with a 4xx status as failed logins and those with a 5xx
+// status as errors. Successes are counted by LoginSucceeded, since not every OK response is a
+// login.
+func LoginAttempts(method models.LoginMethod, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		switch {
+		case rec.status >= 500:
+			logins.WithLabelValues(string(method), "error").Inc()
+		case rec.status >= 400:
+			logins.WithLabelValues(string(method), "failure").Inc()
+		}
+	})
+}
+
+// Middleware records the duration of all requests, by the pattern of the route that handled them.
+// It must be the handler closest to the http.ServeMux, since that sets the pattern on the request
+// it's given.
+func Middleware(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.
+			WithLabelValues(route, method(r.Method), strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// Transport records the duration of requests to the given upstream.
+func Transport(upstream string, next http.RoundTripper) http.RoundTripper {
+	return roundTripper{upstream, next}
+}
+
+type roundTripper struct {
+	upstream string
+	next     http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequestDuration.
+		WithLabelValues(rt.upstream, method(req.Method), code).
+		Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// method keeps clients from creating any number of label values.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush is needed for server-sent events, which check for it with a type assertion.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -13,9 +13,21 @@ type authRequest struct {
 	authCode string
 	inner    *oidc.AuthRequest
 	subject  string
+	// actor is the kthid of the admin impersonating the subject, if any.
+	actor string
 }
 
 var _ op.AuthRequest = authRequest{}
+var _ op.TokenActorRequest = authRequest{}
+
+// GetActor implements op.TokenActorRequest. It makes ID tokens issued during impersonation get an
+// `act` claim (RFC 8693) with the admin as the subject.
+func (a authRequest) GetActor() *oidc.ActorClaims {
+	if a.actor == "" {
+		return nil
+	}
+	return &oidc.ActorClaims{Subject: a.actor}
+}
 
 // Done implements op.AuthRequest.
 func (a authRequest) Done() bool {
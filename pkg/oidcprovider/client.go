@@ -6,7 +6,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/datasektionen/sso/pkg/config"
 	"github.com/zitadel/oidc/v3/pkg/oidc"
 	"github.com/zitadel/oidc/v3/pkg/op"
 )
@@ -14,6 +13,7 @@ import (
 type client struct {
 	id           string
 	redirectURIs []string
+	dev          bool
 }
 
 var _ op.Client = client{}
@@ -40,7 +40,7 @@ func (c client) ClockSkew() time.Duration {
 
 // DevMode implements op.Client.
 func (c client) DevMode() bool {
-	return config.Config.Dev
+	return c.dev
 }
 
 // GetID implements op.Client.
@@ -19,11 +19,9 @@ import (
 	"time"
 
 	"github.com/datasektionen/sso/models"
-	"github.com/datasektionen/sso/pkg/config"
-	"github.com/datasektionen/sso/pkg/hive"
 	"github.com/datasektionen/sso/pkg/httputil"
-	"github.com/datasektionen/sso/pkg/pls"
-	"github.com/datasektionen/sso/pkg/rfinger"
+	"github.com/datasektionen/sso/pkg/logging"
+	"github.com/datasektionen/sso/pkg/metrics"
 	"github.com/datasektionen/sso/service"
 	"github.com/datasektionen/sso/templates"
 
@@ -58,7 +56,7 @@ type accessToken struct {
 
 var _ op.Storage = &provider{}
 
-var supportedScopes = []string{"openid", "profile", "email", "offline_access", "pls_*", "permissions", "permissions_flat", "picture", "year_tag"}
+var supportedScopes = []string{"openid", "profile", "email", "offline_access", "pls_*", "permissions", "permissions_flat", "picture", "year_tag", "membership"}
 
 func Init(s *service.Service) (http.Handler, error) {
 	// Yes, the initialization of this key does indeed seem very shady. I do
@@ -78,7 +76,7 @@ func Init(s *service.Service) (http.Handler, error) {
 		Primes: []*big.Int{},
 	}
 	{
-		parts := strings.SplitN(config.Config.OIDCProviderKey, ",", 3)
+		parts := strings.SplitN(s.Cfg.OIDCProviderKey, ",", 3)
 		if len(parts) != 2 {
 			return nil, errors.New("Expected $OIDC_PROVIDER_KEY to have two comma-separated prime numbers in base 62")
 		}
@@ -111,7 +109,7 @@ func Init(s *service.Service) (http.Handler, error) {
 		s:      s,
 	}
 	var opts []op.Option
-	if config.Config.Dev {
+	if s.Cfg.Dev {
 		opts = append(opts, op.WithAllowInsecure())
 	}
 	var key [32]byte
@@ -132,21 +130,19 @@ func Init(s *service.Service) (http.Handler, error) {
 			"pls_*",
 			"permissions",
 			"year_tag",
+			"member_to", "active_member",
+			"act",
 		},
 		SupportedScopes: supportedScopes,
 	},
 		p,
-		op.StaticIssuer(config.Config.OIDCProviderIssuerURL.String()),
+		op.StaticIssuer(s.Cfg.OIDCProviderIssuerURL.String()),
 		opts...,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	if config.Config.OIDCProviderIssuerURL.Path != "/op" {
-		return nil, errors.New("The path of $OIDC_PROVIDER_ISSUER_URL must be `/`")
-	}
-
 	return p, nil
 }
 
@@ -155,6 +151,12 @@ func (p *provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/op/sso-done" {
 		next = httputil.Route(p.s, p.callback)
 	} else {
+		if r.URL.Path == "/op/oauth/token" {
+			if err := p.s.RateLimit(r, "token", p.s.Cfg.RateLimitToken, httputil.ClientIP(r)); err != nil {
+				httputil.Respond(err, w, r)
+				return
+			}
+		}
 		next = http.StripPrefix("/op", p.provider.Handler)
 	}
 	next.ServeHTTP(w, r)
@@ -175,24 +177,41 @@ func (p *provider) callback(_ *service.Service, w http.ResponseWriter, r *http.R
 
 	user := p.s.GetLoggedInUser(r)
 	guest := p.s.GetLoggedInGuestUser(r)
+	if user == nil && guest == nil {
+		return httputil.BadRequest("User did not seem to get logged in")
+	}
+	client, err := p.s.DB.GetClient(r.Context(), req.GetClientID())
+	if err != nil {
+		return err
+	}
 	if user != nil {
+		if client.MembersOnly && !models.IsActiveMember(user) {
+			metrics.OIDCLogins.WithLabelValues(client.ID, "not-a-member").Inc()
+			return templates.NotAMember(user.MemberTo)
+		}
 		req.subject = url.Values{"kthid": {user.KTHID}}.Encode()
-	} else if guest != nil {
-		if client, err := p.s.DB.GetClient(r.Context(), req.GetClientID()); err != nil {
-			return err
-		} else if !client.AllowGuests {
+		req.actor = p.s.GetImpersonator(r)
+		if req.actor != "" {
+			p.s.Audit(r, "impersonation.oidc-login", client.ID, nil, nil)
+		}
+	} else {
+		if !client.AllowGuests {
+			metrics.OIDCLogins.WithLabelValues(client.ID, "no-account").Inc()
 			return templates.MissingAccount()
 		}
+		if client.MembersOnly {
+			metrics.OIDCLogins.WithLabelValues(client.ID, "not-a-member").Inc()
+			return templates.NotAMember(time.Time{})
+		}
 
 		guestJSON, err := json.Marshal(*guest)
 		if err != nil {
 			return err
 		}
 		req.subject = url.Values{"guest": {string(guestJSON)}}.Encode()
-	} else {
-		return httputil.BadRequest("User did not seem to get logged in")
 	}
 	p.dotabase.reqByID[id] = req
+	metrics.OIDCLogins.WithLabelValues(client.ID, "ok").Inc()
 
 	return httputil.Redirect("/op" + op.AuthCallbackURL(p.provider)(r.Context(), authRequestID))
 }
@@ -229,7 +248,7 @@ func (p *provider) AuthRequestByID(ctx context.Context, authRequestID string) (o
 
 // CreateAccessAndRefreshTokens implements op.Storage.
 func (p *provider) CreateAccessAndRefreshTokens(ctx context.Context, request op.TokenRequest, currentRefreshToken string) (accessTokenID string, newRefreshTokenID string, expiration time.Time, err error) {
-	slog.Warn("oidcprovider.*service.CreateAccessAndRefreshTokens", "request", request, "currentRefreshToken", currentRefreshToken)
+	slog.WarnContext(ctx, "oidcprovider.*service.CreateAccessAndRefreshTokens", "request", request, "currentRefreshToken", currentRefreshToken)
 	panic("unimplemented")
 }
 
@@ -244,14 +263,15 @@ func (p *provider) CreateAccessToken(ctx context.Context, request op.TokenReques
 		// NOTE: our implementation of GetAudience simply returns `[]string{a.GetClientID()}`, but there are other implementations in the library, so I don't know if this is safe or can arbitrarily overwritten by a client. Conclusion: I picked a shitty library
 		clientID: request.GetAudience()[0],
 	}
-	slog.Info("CreateAccessToken", "accessTokenID", tokenID, "request", request)
+	slog.InfoContext(ctx, "CreateAccessToken", "accessTokenID", tokenID, "request", request)
+	metrics.OIDCTokens.WithLabelValues(request.GetAudience()[0], "issued").Inc()
 	return tokenID.String(), time.Now().Add(10 * time.Minute), nil
 }
 
 // CreateAuthRequest implements op.Storage.
 func (p *provider) CreateAuthRequest(ctx context.Context, r *oidc.AuthRequest, userID string) (op.AuthRequest, error) {
 	if userID != "" {
-		slog.Info("oidcprovider.*service.CreateAuthRequest: we got a userID!!!", "userID", userID)
+		slog.InfoContext(ctx, "oidcprovider.*service.CreateAuthRequest: we got a userID!!!", "userID", userID)
 	}
 
 	id := uuid.New()
@@ -295,8 +315,10 @@ func (p *provider) AuthorizeClientIDSecret(ctx context.Context, clientID string,
 	h := sha256.New()
 	h.Write(secret)
 	if subtle.ConstantTimeCompare(client.SecretHash, h.Sum(nil)) != 1 {
+		metrics.OIDCTokens.WithLabelValues(clientID, "invalid-secret").Inc()
 		return httputil.BadRequest("Invalid client secret")
 	}
+	logging.SetClient(ctx, clientID)
 	return nil
 }
 
@@ -313,36 +335,37 @@ func (p *provider) GetClientByClientID(ctx context.Context, clientID string) (op
 	return client{
 		id:           c.ID,
 		redirectURIs: c.RedirectUris,
+		dev:          p.s.Cfg.Dev,
 	}, nil
 }
 
 // GetKeyByIDAndClientID implements op.Storage.
 func (p *provider) GetKeyByIDAndClientID(ctx context.Context, keyID string, clientID string) (*jose.JSONWebKey, error) {
-	slog.Warn("oidcprovider.*service.GetKeyByIDAndClientID", "keyID", keyID, "clientID", clientID)
+	slog.WarnContext(ctx, "oidcprovider.*service.GetKeyByIDAndClientID", "keyID", keyID, "clientID", clientID)
 	panic("unimplemented")
 }
 
 // GetPrivateClaimsFromScopes implements op.Storage.
 func (p *provider) GetPrivateClaimsFromScopes(ctx context.Context, userID string, clientID string, scopes []string) (map[string]any, error) {
-	slog.Warn("oidcprovider.*service.GetPrivateClaimsFromScopes", "userID", userID, "clientID", clientID, "scopes", scopes)
+	slog.WarnContext(ctx, "oidcprovider.*service.GetPrivateClaimsFromScopes", "userID", userID, "clientID", clientID, "scopes", scopes)
 	panic("unimplemented")
 }
 
 // GetRefreshTokenInfo implements op.Storage.
 func (p *provider) GetRefreshTokenInfo(ctx context.Context, clientID string, token string) (userID string, tokenID string, err error) {
-	slog.Warn("oidcprovider.*service.GetRefreshTokenInfo", "clientID", clientID, "token", token)
+	slog.WarnContext(ctx, "oidcprovider.*service.GetRefreshTokenInfo", "clientID", clientID, "token", token)
 	panic("unimplemented")
 }
 
 // Health implements op.Storage.
 func (p *provider) Health(ctx context.Context) error {
-	slog.Warn("oidcprovider.*service.Health")
+	slog.WarnContext(ctx, "oidcprovider.*service.Health")
 	panic("unimplemented")
 }
 
 // RevokeToken implements op.Storage.
 func (p *provider) RevokeToken(ctx context.Context, tokenOrTokenID string, userID string, clientID string) *oidc.Error {
-	slog.Warn("oidcprovider.*service.RevokeToken", "tokenOrTokenID", tokenOrTokenID, "userID", userID, "clientID", clientID)
+	slog.WarnContext(ctx, "oidcprovider.*service.RevokeToken", "tokenOrTokenID", tokenOrTokenID, "userID", userID, "clientID", clientID)
 	panic("unimplemented")
 }
 
@@ -366,7 +389,7 @@ func (p *provider) SaveAuthCode(ctx context.Context, authRequestID string, code
 
 // SetIntrospectionFromToken implements op.Storage.
 func (p *provider) SetIntrospectionFromToken(ctx context.Context, userinfo *oidc.IntrospectionResponse, tokenID string, subject string, clientID string) error {
-	slog.Warn("oidcprovider.*service.SetIntrospectionFromToken", "userinfo", userinfo, "tokenID", tokenID, "subject", subject, "clientID", clientID)
+	slog.WarnContext(ctx, "oidcprovider.*service.SetIntrospectionFromToken", "userinfo", userinfo, "tokenID", tokenID, "subject", subject, "clientID", clientID)
 	panic("unimplemented")
 }
 
@@ -377,7 +400,7 @@ func (p *provider) SetUserinfoFromScopes(ctx context.Context, userinfo *oidc.Use
 		return err
 	}
 	if user == nil && guest == nil {
-		slog.Error("SetUserinfoFromScopes: user not found", "subject", subject, "clientID", clientID, "scopes", scopes)
+		slog.ErrorContext(ctx, "SetUserinfoFromScopes: user not found", "subject", subject, "clientID", clientID, "scopes", scopes)
 		return errors.New("SetUserinfoFromScopes, no user found but pretty sure that should have been handled in this request???")
 	}
 
@@ -385,16 +408,16 @@ func (p *provider) SetUserinfoFromScopes(ctx context.Context, userinfo *oidc.Use
 	if err != nil {
 		return err
 	}
-	if err := setUserinfo(ctx, userinfo, user, guest, scopes, client.HiveSystemID); err != nil {
+	if err := p.setUserinfo(ctx, userinfo, user, guest, scopes, client.HiveSystemID); err != nil {
 		return err
 	}
-	slog.Info("oidcprovider.*service.SetUserinfoFromScopes", "userinfo", userinfo)
+	slog.InfoContext(ctx, "oidcprovider.*service.SetUserinfoFromScopes", "userinfo", userinfo)
 	return nil
 }
 
 // SetUserinfoFromToken implements op.Storage.
 func (p *provider) SetUserinfoFromToken(ctx context.Context, userinfo *oidc.UserInfo, tokenID, subject, origin string) error {
-	slog.Warn("oidcprovider.*service.SetUserinfoFromToken", "tokenID", tokenID, "subject", subject, "origin", origin)
+	slog.WarnContext(ctx, "oidcprovider.*service.SetUserinfoFromToken", "tokenID", tokenID, "subject", subject, "origin", origin)
 	accessTokenID, err := uuid.Parse(tokenID)
 	if err != nil {
 		return httputil.BadRequest("SetUserinfoFromToken: invalid uuid syntax in token id")
@@ -412,7 +435,7 @@ func (p *provider) SetUserinfoFromToken(ctx context.Context, userinfo *oidc.User
 		return err
 	}
 	if user == nil && guest == nil {
-		slog.Error("SetUserinfoFromToken: user not found", "subject", subject)
+		slog.ErrorContext(ctx, "SetUserinfoFromToken: user not found", "subject", subject)
 		return errors.New("SetUserinfoFromToken, no user but pretty sure that should have been handled in this request???")
 	}
 
@@ -420,15 +443,16 @@ func (p *provider) SetUserinfoFromToken(ctx context.Context, userinfo *oidc.User
 	if err != nil {
 		return err
 	}
-	if err := setUserinfo(ctx, userinfo, user, guest, token.scopes, client.HiveSystemID); err != nil {
+	logging.SetClient(ctx, token.clientID)
+	if err := p.setUserinfo(ctx, userinfo, user, guest, token.scopes, client.HiveSystemID); err != nil {
 		return err
 	}
 
-	slog.Info("oidcprovider.*service.SetUserinfoFromToken", "userinfo", userinfo, "scopes", token.scopes)
+	slog.InfoContext(ctx, "oidcprovider.*service.SetUserinfoFromToken", "userinfo", userinfo, "scopes", token.scopes)
 	return nil
 }
 
-func setUserinfo(ctx context.Context, userinfo *oidc.UserInfo, user *models.User, guest *models.GuestUser, scopes []string, system string) error {
+func (p *provider) setUserinfo(ctx context.Context, userinfo *oidc.UserInfo, user *models.User, guest *models.GuestUser, scopes []string, system string) error {
 	if user == nil {
 		user = &models.User{
 			KTHID:      guest.KTHID,
@@ -441,7 +465,7 @@ func setUserinfo(ctx context.Context, userinfo *oidc.UserInfo, user *models.User
 		userinfo.Claims = make(map[string]any)
 	}
 
-	slog.Info("setuserinfo", "scopes", scopes)
+	slog.InfoContext(ctx, "setuserinfo", "scopes", scopes)
 
 	for _, scope := range scopes {
 		switch scope {
@@ -463,9 +487,9 @@ func setUserinfo(ctx context.Context, userinfo *oidc.UserInfo, user *models.User
 				return httputil.BadRequest("Hive System ID must be set in the admin console when requesting permissions through OIDC")
 			}
 			if guest == nil {
-				perms, err := hive.GetRawPermissionsInSystemForUser(ctx, user.KTHID, system)
+				perms, err := p.s.Hive.GetCachedRawPermissionsInSystemForUser(ctx, user.KTHID, system)
 				if err != nil {
-					slog.Error("setUserinfo: error getting permissions", "err", err)
+					slog.ErrorContext(ctx, "setUserinfo: error getting permissions", "err", err)
 					return err
 				}
 				userinfo.Claims[scope] = perms
@@ -475,9 +499,9 @@ func setUserinfo(ctx context.Context, userinfo *oidc.UserInfo, user *models.User
 				return httputil.BadRequest("Hive System ID must be set in the admin console when requesting permissions through OIDC")
 			}
 			if guest == nil {
-				perms, err := hive.GetRawPermissionsInSystemForUser(ctx, user.KTHID, system)
+				perms, err := p.s.Hive.GetCachedRawPermissionsInSystemForUser(ctx, user.KTHID, system)
 				if err != nil {
-					slog.Error("setUserinfo: error getting permissions", "err", err)
+					slog.ErrorContext(ctx, "setUserinfo: error getting permissions", "err", err)
 					return err
 				}
 				var flatPerms []string
@@ -492,19 +516,24 @@ func setUserinfo(ctx context.Context, userinfo *oidc.UserInfo, user *models.User
 			}
 		case "year_tag":
 			userinfo.Claims[scope] = user.YearTag
+		case "membership":
+			if user.MemberTo != (time.Time{}) {
+				userinfo.Claims["member_to"] = user.MemberTo.Format(time.DateOnly)
+			}
+			userinfo.Claims["active_member"] = guest == nil && models.IsActiveMember(user)
 		case "picture":
-			picture, err := rfinger.GetPicture(ctx, user.KTHID, false)
+			picture, err := p.s.Rfinger.GetPicture(ctx, user.KTHID, false)
 			if err != nil {
-				slog.Error("setUserinfo: error getting picture", "err", err)
+				slog.ErrorContext(ctx, "setUserinfo: error getting picture", "err", err)
 				return err
 			}
 			userinfo.Picture = picture
 		default:
 			if group, ok := strings.CutPrefix(scope, "pls_"); ok {
 				if guest == nil {
-					perms, err := pls.GetUserPermissionsForGroup(ctx, user.KTHID, group)
+					perms, err := p.s.Pls.GetUserPermissionsForGroup(ctx, user.KTHID, group)
 					if err != nil {
-						slog.Error("setUserinfo: error getting permissions", "err", err)
+						slog.ErrorContext(ctx, "setUserinfo: error getting permissions", "err", err)
 						return err
 					}
 					userinfo.Claims[scope] = perms
@@ -550,19 +579,19 @@ func (p *provider) SigningKey(ctx context.Context) (op.SigningKey, error) {
 
 // TerminateSession implements op.Storage.
 func (p *provider) TerminateSession(ctx context.Context, userID string, clientID string) error {
-	slog.Warn("oidcprovider.*service.TerminateSession", "userID", userID, "clientID", clientID)
+	slog.WarnContext(ctx, "oidcprovider.*service.TerminateSession", "userID", userID, "clientID", clientID)
 	panic("unimplemented")
 }
 
 // TokenRequestByRefreshToken implements op.Storage.
 func (p *provider) TokenRequestByRefreshToken(ctx context.Context, refreshTokenID string) (op.RefreshTokenRequest, error) {
-	slog.Warn("oidcprovider.*service.TokenRequestByRefreshToken", "refreshTokenID", refreshTokenID)
+	slog.WarnContext(ctx, "oidcprovider.*service.TokenRequestByRefreshToken", "refreshTokenID", refreshTokenID)
 	panic("unimplemented")
 }
 
 // ValidateJWTProfileScopes implements op.Storage.
 func (p *provider) ValidateJWTProfileScopes(ctx context.Context, userID string, scopes []string) ([]string, error) {
-	slog.Warn("oidcprovider.*service.ValidateJWTProfileScopes", "userID", userID, "scopes", scopes)
+	slog.WarnContext(ctx, "oidcprovider.*service.ValidateJWTProfileScopes", "userID", userID, "scopes", scopes)
 	panic("unimplemented")
 }
 
@@ -12,29 +12,45 @@ import (
 	"github.com/datasektionen/sso/pkg/config"
 )
 
-func CheckUser(ctx context.Context, kthid, permission string) (bool, error) {
-	return check(ctx, "user", kthid, permission)
+type Client struct {
+	cfg  *config.Cfg
+	http *http.Client
 }
 
-func CheckToken(ctx context.Context, token, permission string) (bool, error) {
-	return check(ctx, "token", token, permission)
+// NewClient creates a client for the pls instance at cfg.PlsURL. If that is
+// nil, all permission checks succeed. If httpClient is nil,
+// http.DefaultClient is used.
+func NewClient(cfg *config.Cfg, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, http: httpClient}
+}
+
+func (c *Client) CheckUser(ctx context.Context, kthid, permission string) (bool, error) {
+	return c.check(ctx, "user", kthid, permission)
+}
+
+func (c *Client) CheckToken(ctx context.Context, token, permission string) (bool, error) {
+	return c.check(ctx, "token", token, permission)
 }
 
-func GetUserPermissionsForGroup(ctx context.Context, kthid string, group string) ([]string, error) {
+func (c *Client) GetUserPermissionsForGroup(ctx context.Context, kthid string, group string) ([]string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
 		"%s/api/user/%s/%s",
-		config.Config.PlsURL.String(),
+		c.cfg.PlsURL.String(),
 		url.PathEscape(kthid),
 		url.PathEscape(group),
 	), nil)
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("pls getPermissions", "pls_url", config.Config.PlsURL.String(), "url", req.URL)
-	resp, err := http.DefaultClient.Do(req)
+	slog.InfoContext(ctx, "pls getPermissions", "pls_url", c.cfg.PlsURL.String(), "url", req.URL)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Unexpected status code from pls: %d", resp.StatusCode)
 	}
@@ -45,8 +61,8 @@ func GetUserPermissionsForGroup(ctx context.Context, kthid string, group string)
 	return body, nil
 }
 
-func check(ctx context.Context, kind, who, permission string) (bool, error) {
-	if config.Config.PlsURL == nil {
+func (c *Client) check(ctx context.Context, kind, who, permission string) (bool, error) {
+	if c.cfg.PlsURL == nil {
 		return true, nil
 	}
 
@@ -58,7 +74,7 @@ func check(ctx context.Context, kind, who, permission string) (bool, error) {
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
 		"%s/api/%s/%s/%s/%s",
-		config.Config.PlsURL.String(),
+		c.cfg.PlsURL.String(),
 		url.PathEscape(kind),
 		url.PathEscape(who),
 		url.PathEscape(system),
@@ -67,11 +83,12 @@ func check(ctx context.Context, kind, who, permission string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	slog.Info("pls check", "pls_url", config.Config.PlsURL.String(), "url", req.URL)
-	resp, err := http.DefaultClient.Do(req)
+	slog.InfoContext(ctx, "pls check", "pls_url", c.cfg.PlsURL.String(), "url", req.URL)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return false, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		if kind == "token" {
 			// Pls returns 500 when the token doesn't exist (what???)
@@ -0,0 +1,74 @@
+// Package ratelimit counts requests per key in fixed windows.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/datasektionen/sso/database"
+)
+
+type Store interface {
+	// Hit counts a request for key and returns the number of requests in the current window,
+	// including this one, and how long is left of the window. A new window of the given length
+	// starts with the first request after the previous one has ended.
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+}
+
+// Postgres keeps the counts in the database, so they're shared between replicas.
+type Postgres struct {
+	db *database.Queries
+}
+
+func NewPostgres(db *database.Queries) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	row, err := p.db.HitRateLimit(ctx, database.HitRateLimitParams{
+		Key:           key,
+		WindowSeconds: int32(window.Seconds()),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(row.Count), time.Duration(row.SecondsLeft) * time.Second, nil
+}
+
+// Memory keeps the counts in memory, which is only correct when running a single replica.
+type Memory struct {
+	mu        sync.Mutex
+	windows   map[string]memoryWindow
+	lastPrune time.Time
+}
+
+type memoryWindow struct {
+	count int
+	end   time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{windows: make(map[string]memoryWindow)}
+}
+
+func (m *Memory) Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastPrune) > time.Minute {
+		for k, w := range m.windows {
+			if !now.Before(w.end) {
+				delete(m.windows, k)
+			}
+		}
+		m.lastPrune = now
+	}
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.end) {
+		w = memoryWindow{end: now.Add(window)}
+	}
+	w.count++
+	m.windows[key] = w
+	return w.count, w.end.Sub(now), nil
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	for want := 1; want <= 3; want++ {
+		count, left, err := m.Hit(ctx, "login:127.0.0.1", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want || left <= 0 || left > time.Hour {
+			t.Fatalf("Expected hit %d with at most an hour left, got %d with %s left", want, count, left)
+		}
+	}
+	if count, _, _ := m.Hit(ctx, "login:127.0.0.2", time.Hour); count != 1 {
+		t.Fatalf("Expected keys to be counted separately, got %d", count)
+	}
+
+	// A new window starts with the first hit after the previous one has ended.
+	m.expire("login:127.0.0.1")
+	if count, left, _ := m.Hit(ctx, "login:127.0.0.1", time.Minute); count != 1 || left > time.Minute {
+		t.Fatalf("Expected a new window, got %d with %s left", count, left)
+	}
+}
+
+func TestMemoryPrune(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Hit(ctx, "a", time.Hour)
+	m.Hit(ctx, "b", time.Hour)
+	m.expire("a")
+	m.lastPrune = time.Now().Add(-2 * time.Minute)
+	m.Hit(ctx, "c", time.Hour)
+	if _, ok := m.windows["a"]; ok || len(m.windows) != 2 {
+		t.Fatalf("Expected only the ended window to be pruned, got %v", m.windows)
+	}
+}
+
+// expire ends the current window for key.
+func (m *Memory) expire(key string) {
+	w := m.windows[key]
+	w.end = time.Now().Add(-time.Second)
+	m.windows[key] = w
+}
@@ -13,10 +13,29 @@ import (
 	"github.com/datasektionen/sso/pkg/config"
 )
 
-func GetPicture(ctx context.Context, kthid string, quality bool) (string, error) {
+type Client struct {
+	cfg  *config.Cfg
+	http *http.Client
+}
+
+// NewClient creates a client for the rfinger instance at cfg.RfingerURL. If
+// httpClient is nil, http.DefaultClient is used.
+func NewClient(cfg *config.Cfg, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, http: httpClient}
+}
+
+// Enabled reports whether an rfinger URL has been configured.
+func (c *Client) Enabled() bool {
+	return c.cfg.RfingerURL != nil
+}
+
+func (c *Client) GetPicture(ctx context.Context, kthid string, quality bool) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
 		"%s/api/%s?quality=%t",
-		config.Config.RfingerURL.String(),
+		c.cfg.RfingerURL.String(),
 		url.PathEscape(kthid),
 		quality,
 	), nil)
@@ -25,13 +44,14 @@ func GetPicture(ctx context.Context, kthid string, quality bool) (string, error)
 		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+config.Config.RfingerAPIKey)
-	slog.Info("rfinger getPicture", "rfinger_url", config.Config.RfingerURL.String(), "url", req.URL)
-	resp, err := http.DefaultClient.Do(req)
+	req.Header.Set("Authorization", "Bearer "+c.cfg.RfingerAPIKey)
+	slog.InfoContext(ctx, "rfinger getPicture", "rfinger_url", c.cfg.RfingerURL.String(), "url", req.URL)
+	resp, err := c.http.Do(req)
 
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("Unexpected status code from rfinger: %d", resp.StatusCode)
@@ -48,7 +68,7 @@ func GetPicture(ctx context.Context, kthid string, quality bool) (string, error)
 	return body, nil
 }
 
-func GetPictures(ctx context.Context, kthid []string, quality bool) (map[string]string, error) {
+func (c *Client) GetPictures(ctx context.Context, kthid []string, quality bool) (map[string]string, error) {
 	queryBody, err := json.Marshal(kthid)
 
 	if err != nil {
@@ -57,22 +77,23 @@ func GetPictures(ctx context.Context, kthid []string, quality bool) (map[string]
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(
 		"%s/api/batch?quality=%t",
-		config.Config.RfingerURL.String(),
+		c.cfg.RfingerURL.String(),
 		quality,
 	), bytes.NewBuffer([]byte(queryBody)))
 
-	req.Header.Set("Authorization", "Bearer "+config.Config.RfingerAPIKey)
-
 	if err != nil {
 		return nil, err
 	}
 
-	slog.Info("rfinger getPicture", "rfinger_url", config.Config.RfingerURL.String(), "url", req.URL)
-	resp, err := http.DefaultClient.Do(req)
+	req.Header.Set("Authorization", "Bearer "+c.cfg.RfingerAPIKey)
+
+	slog.InfoContext(ctx, "rfinger getPicture", "rfinger_url", c.cfg.RfingerURL.String(), "url", req.URL)
+	resp, err := c.http.Do(req)
 
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Unexpected status code from rfinger: %d", resp.StatusCode)
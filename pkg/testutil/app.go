@@ -0,0 +1,253 @@
+package testutil
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/handlers"
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/httputil"
+	"github.com/datasektionen/sso/pkg/logging"
+	"github.com/datasektionen/sso/pkg/metrics"
+	"github.com/datasektionen/sso/pkg/oidcprovider"
+	"github.com/datasektionen/sso/pkg/static"
+	"github.com/datasektionen/sso/pkg/tracing"
+	"github.com/datasektionen/sso/service"
+)
+
+// App is a complete SSO instance served over TLS by httptest. It runs against
+// a throwaway database created on the Postgres server at $TEST_DATABASE_URL,
+// with all upstreams (including KTH's OIDC provider) replaced by fakes.
+type App struct {
+	URL       *url.URL
+	Cfg       *config.Cfg
+	Service   *service.Service
+	Upstreams *Upstreams
+	KTH       *KTH
+	server    *httptest.Server
+}
+
+// NewApp starts an App, skipping the test if $TEST_DATABASE_URL is not set.
+// Everything is torn down when the test finishes.
+func NewApp(t testing.TB) *App {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	databaseURL := newDatabase(t, ctx)
+
+	a := &App{
+		Upstreams: NewUpstreams(t),
+		KTH:       NewKTH(t),
+		// The listener is created right away, so we know our own URL before
+		// starting to serve.
+		server: httptest.NewUnstartedServer(nil),
+	}
+	t.Cleanup(a.server.Close)
+	a.URL = &url.URL{Scheme: "https", Host: a.server.Listener.Addr().String()}
+
+	a.Cfg = &config.Cfg{
+		KTHOIDCIssuerURL:      a.KTH.URL,
+		KTHOIDCClientID:       a.KTH.ClientID,
+		KTHOIDCClientSecret:   "kth-secret",
+		KTHOIDCRPOrigin:       a.URL,
+		Origin:                a.URL,
+		DatabaseURL:           databaseURL,
+		OIDCProviderIssuerURL: a.URL.JoinPath("/op"),
+		OIDCProviderKey:       newOIDCProviderKey(t),
+		HiveCacheTTL:          time.Minute,
+		HiveCacheGrace:        time.Hour,
+		HiveCacheSize:         1024,
+		PermissionsRefresh:    5 * time.Minute,
+
+		SessionIdleTimeout:           8 * time.Hour,
+		SessionMaxAge:                24 * time.Hour,
+		RememberedSessionIdleTimeout: 30 * 24 * time.Hour,
+		RememberedSessionMaxAge:      90 * 24 * time.Hour,
+	}
+	a.Upstreams.Configure(a.Cfg)
+
+	db, err := database.ConnectAndMigrate(ctx, a.Cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Service, err = service.NewService(ctx, a.Cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := oidcprovider.Init(a.Service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	handlers.MountRoutes(a.Service, mux, true)
+	mux.Handle("/op/", op)
+	static.Mount(mux)
+	a.server.Config.Handler = logging.Middleware(httputil.SecurityHeaders(a.Cfg, tracing.Middleware(metrics.Middleware(logging.AccessLog(mux)))))
+	a.server.StartTLS()
+
+	return a
+}
+
+// newDatabase creates an empty database which is dropped when the test
+// finishes.
+func newDatabase(t testing.TB, ctx context.Context) *url.URL {
+	t.Helper()
+	base, ok := os.LookupEnv("TEST_DATABASE_URL")
+	if !ok {
+		t.Skip("$TEST_DATABASE_URL is not set")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pgx.Connect(ctx, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "sso_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := conn.Exec(ctx, "create database "+name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if _, err := conn.Exec(ctx, "drop database "+name+" with (force)"); err != nil {
+			t.Error(err)
+		}
+		_ = conn.Close(ctx)
+	})
+	u.Path = "/" + name
+	return u
+}
+
+// newOIDCProviderKey does what `manage gen-oidc-provider-key` does, but with
+// a smaller key since it's done for every test.
+func newOIDCProviderKey(t testing.TB) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.Primes[0].Text(62) + "," + key.Primes[1].Text(62)
+}
+
+// Browser is an HTTP client with its own cookies and its own Authenticator.
+// It follows redirects within the App and to the fake KTH, but stops at
+// redirects anywhere else, e.g. to an OIDC client's redirect URI.
+type Browser struct {
+	t             testing.TB
+	app           *App
+	Client        *http.Client
+	Authenticator *Authenticator
+}
+
+func (a *App) NewBrowser(t testing.TB) *Browser {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Browser{
+		t:   t,
+		app: a,
+		Client: &http.Client{
+			Transport: a.server.Client().Transport,
+			Jar:       jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Host != a.URL.Host && req.URL.Host != a.KTH.URL.Host {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		},
+		Authenticator: NewAuthenticator(a.URL.String()),
+	}
+}
+
+// Do performs the request, failing the test if that doesn't work. The
+// response body is read and returned as a string.
+func (b *Browser) Do(req *http.Request) (*http.Response, string) {
+	b.t.Helper()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && req.URL.Host == b.app.URL.Host {
+		// Like the scripts in the pages do.
+		if req.Header.Get(httputil.CSRFHeader) == "" {
+			req.Header.Set(httputil.CSRFHeader, b.csrfToken())
+		}
+		if req.Header.Get("Origin") == "" {
+			req.Header.Set("Origin", b.app.URL.Scheme+"://"+b.app.URL.Host)
+		}
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func (b *Browser) Get(path string) (*http.Response, string) {
+	b.t.Helper()
+	return b.Do(b.newRequest(http.MethodGet, path, nil, ""))
+}
+
+func (b *Browser) PostForm(path string, form url.Values) (*http.Response, string) {
+	b.t.Helper()
+	return b.Do(b.newRequest(http.MethodPost, path, strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"))
+}
+
+func (b *Browser) PostJSON(path string, body string) (*http.Response, string) {
+	b.t.Helper()
+	return b.Do(b.newRequest(http.MethodPost, path, strings.NewReader(body), "application/json"))
+}
+
+// csrfToken returns the browser's CSRF token, loading a page first if it doesn't have one yet.
+func (b *Browser) csrfToken() string {
+	b.t.Helper()
+	for range 2 {
+		for _, c := range b.Client.Jar.Cookies(b.app.URL) {
+			if c.Name == httputil.CSRFCookie {
+				return c.Value
+			}
+		}
+		b.Get("/ping")
+	}
+	b.t.Fatal("Did not get a CSRF token")
+	return ""
+}
+
+// newRequest creates a request to the given URL, which is resolved relative
+// to the App's URL.
+func (b *Browser) newRequest(method, path string, body io.Reader, contentType string) *http.Request {
+	b.t.Helper()
+	u, err := b.app.URL.Parse(path)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
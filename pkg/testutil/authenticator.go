@@ -0,0 +1,215 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/google/uuid"
+)
+
+// Authenticator is a virtual WebAuthn authenticator, playing the part of both
+// the browser and the security key. It creates discoverable ES256
+// credentials with "none" attestation and always reports the user as present
+// and verified.
+type Authenticator struct {
+	// AAGUID is reported when creating credentials. Since the attestation is
+	// "none", nothing vouches for it. Zero by default.
+	AAGUID uuid.UUID
+
+	origin string
+	mu     sync.Mutex
+	creds  []*virtualCredential
+}
+
+type virtualCredential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// NewAuthenticator creates an authenticator without any credentials, acting
+// as a browser on the given origin.
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{origin: origin}
+}
+
+// Clone returns an authenticator with copies of all credentials, including their sign counts, like
+// what someone who has extracted the keys would have.
+func (a *Authenticator) Clone() *Authenticator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clone := &Authenticator{AAGUID: a.AAGUID, origin: a.origin}
+	for _, cred := range a.creds {
+		c := *cred
+		clone.creds = append(clone.creds, &c)
+	}
+	return clone
+}
+
+// Create does what navigator.credentials.create would do with the given
+// options.
+func (a *Authenticator) Create(cc protocol.CredentialCreation) (protocol.CredentialCreationResponse, error) {
+	opts := cc.Response
+	userHandle, err := userHandleBytes(opts.User.ID)
+	if err != nil {
+		return protocol.CredentialCreationResponse{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return protocol.CredentialCreationResponse{}, err
+	}
+	cred := &virtualCredential{
+		id:         make([]byte, 16),
+		rpID:       opts.RelyingParty.ID,
+		userHandle: userHandle,
+		key:        key,
+	}
+	if _, err := rand.Read(cred.id); err != nil {
+		return protocol.CredentialCreationResponse{}, err
+	}
+
+	clientData, err := a.clientData(protocol.CreateCeremony, opts.Challenge)
+	if err != nil {
+		return protocol.CredentialCreationResponse{}, err
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: key.X.FillBytes(make([]byte, 32)),
+		YCoord: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return protocol.CredentialCreationResponse{}, err
+	}
+	authData := cred.authData(protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData)
+	authData = append(authData, a.AAGUID[:]...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(cred.id)))
+	authData = append(authData, cred.id...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		return protocol.CredentialCreationResponse{}, err
+	}
+
+	a.mu.Lock()
+	a.creds = append(a.creds, cred)
+	a.mu.Unlock()
+
+	var resp protocol.CredentialCreationResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(cred.id)
+	resp.RawID = cred.id
+	resp.Type = string(protocol.PublicKeyCredentialType)
+	resp.AuthenticatorAttachment = string(protocol.Platform)
+	resp.AttestationResponse.ClientDataJSON = clientData
+	resp.AttestationResponse.AttestationObject = attestationObject
+	return resp, nil
+}
+
+// Get does what navigator.credentials.get would do with the given options. If
+// the options don't list any allowed credentials, the most recently created
+// credential for the relying party is used.
+func (a *Authenticator) Get(ca protocol.CredentialAssertion) (protocol.CredentialAssertionResponse, error) {
+	opts := ca.Response
+	a.mu.Lock()
+	var cred *virtualCredential
+	for i := len(a.creds) - 1; i >= 0 && cred == nil; i-- {
+		c := a.creds[i]
+		if c.rpID != opts.RelyingPartyID {
+			continue
+		}
+		if len(opts.AllowedCredentials) == 0 {
+			cred = c
+		}
+		for _, allowed := range opts.AllowedCredentials {
+			if string(allowed.CredentialID) == string(c.id) {
+				cred = c
+			}
+		}
+	}
+	if cred != nil {
+		cred.signCount++
+	}
+	a.mu.Unlock()
+	if cred == nil {
+		return protocol.CredentialAssertionResponse{}, errors.New("No matching credential")
+	}
+
+	clientData, err := a.clientData(protocol.AssertCeremony, opts.Challenge)
+	if err != nil {
+		return protocol.CredentialAssertionResponse{}, err
+	}
+	authData := cred.authData(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return protocol.CredentialAssertionResponse{}, err
+	}
+
+	var resp protocol.CredentialAssertionResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(cred.id)
+	resp.RawID = cred.id
+	resp.Type = string(protocol.PublicKeyCredentialType)
+	resp.AuthenticatorAttachment = string(protocol.Platform)
+	resp.AssertionResponse.ClientDataJSON = clientData
+	resp.AssertionResponse.AuthenticatorData = authData
+	resp.AssertionResponse.Signature = signature
+	resp.AssertionResponse.UserHandle = cred.userHandle
+	return resp, nil
+}
+
+func (a *Authenticator) clientData(ceremony protocol.CeremonyType, challenge []byte) ([]byte, error) {
+	return json.Marshal(protocol.CollectedClientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+}
+
+// authData returns the authenticator data without attested credential data.
+func (c *virtualCredential) authData(flags protocol.AuthenticatorFlags) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], byte(flags))
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
+
+// userHandleBytes decodes the user handle as it appears in options that have
+// been through JSON, where it is base64url encoded.
+func userHandleBytes(id any) ([]byte, error) {
+	switch id := id.(type) {
+	case []byte:
+		return id, nil
+	case protocol.URLEncodedBase64:
+		return id, nil
+	case string:
+		var b protocol.URLEncodedBase64
+		if err := b.UnmarshalJSON([]byte(`"` + id + `"`)); err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("Unexpected user handle type %T", id)
+	}
+}
@@ -0,0 +1,80 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/email"
+)
+
+const spamAPIKey = "testutil-spam-key"
+
+// Email is an email received by the fake spam.
+type Email struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Content string   `json:"content"`
+}
+
+type Spam struct {
+	fake
+	mu     sync.Mutex
+	emails []Email
+}
+
+// NewSpam starts a fake spam which accepts all emails and keeps them around
+// for inspection.
+func NewSpam(t testing.TB) *Spam {
+	t.Helper()
+	s := &Spam{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/legacy/sendmail", s.sendmail)
+	s.start(t, mux)
+	return s
+}
+
+// Emails returns all emails sent so far, oldest first.
+func (s *Spam) Emails() []Email {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Email(nil), s.emails...)
+}
+
+// LastEmailTo returns the most recent email sent to the given address.
+func (s *Spam) LastEmailTo(recipient string) (Email, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.emails) - 1; i >= 0; i-- {
+		for _, to := range s.emails[i].To {
+			if to == recipient {
+				return s.emails[i], true
+			}
+		}
+	}
+	return Email{}, false
+}
+
+func (s *Spam) Client() *email.Client {
+	return email.NewClient(&config.Cfg{SpamURL: s.URL, SpamAPIKey: spamAPIKey}, s.HTTPClient())
+}
+
+func (s *Spam) sendmail(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Key string `json:"key"`
+		Email
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Key != spamAPIKey {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	s.emails = append(s.emails, body.Email)
+	s.mu.Unlock()
+}
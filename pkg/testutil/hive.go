@@ -0,0 +1,30 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/fakehive"
+	"github.com/datasektionen/sso/pkg/hive"
+)
+
+const hiveAPIKey = "testutil-hive-key"
+
+type Hive struct {
+	fake
+	*fakehive.Server
+}
+
+// NewHive starts a fake Hive with no permissions for anyone. Use Set to grant
+// some.
+func NewHive(t testing.TB) *Hive {
+	t.Helper()
+	h := &Hive{Server: fakehive.New(fakehive.Fixture{})}
+	h.start(t, h.Server)
+	return h
+}
+
+// Client returns a client for the fake, without caching.
+func (h *Hive) Client() *hive.Client {
+	return hive.NewClient(&config.Cfg{HiveURL: h.URL, HiveAPIKey: hiveAPIKey}, h.HTTPClient())
+}
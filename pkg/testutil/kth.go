@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+)
+
+// KTH is a fake of KTH's OIDC provider. There is no login page; instead the
+// authorization endpoint immediately redirects back with a code for whoever
+// was last passed to LogInAs.
+type KTH struct {
+	fake
+	ClientID string
+	key      *rsa.PrivateKey
+
+	mu    sync.Mutex
+	kthid string
+	name  string
+	codes map[string]kthCode
+}
+
+type kthCode struct {
+	kthid    string
+	name     string
+	clientID string
+}
+
+func NewKTH(t testing.TB) *KTH {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &KTH{
+		ClientID: "sso",
+		key:      key,
+		codes:    make(map[string]kthCode),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", k.discovery)
+	mux.HandleFunc("GET /jwks", k.jwks)
+	mux.HandleFunc("GET /authorize", k.authorize)
+	mux.HandleFunc("POST /token", k.token)
+	k.start(t, mux)
+	return k
+}
+
+// LogInAs makes the following authorization requests succeed as the given
+// user. The name is what KTH puts in the `unique_name` claim.
+func (k *KTH) LogInAs(kthid, name string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.kthid = kthid
+	k.name = name
+}
+
+func (k *KTH) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := k.URL.String()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (k *KTH) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &k.key.PublicKey,
+		KeyID:     "kth",
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+func (k *KTH) authorize(w http.ResponseWriter, r *http.Request) {
+	redirectURI, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	k.mu.Lock()
+	if k.kthid == "" {
+		k.mu.Unlock()
+		http.Error(w, "Nobody is logged in at the fake KTH", http.StatusBadRequest)
+		return
+	}
+	code := uuid.NewString()
+	k.codes[code] = kthCode{kthid: k.kthid, name: k.name, clientID: r.FormValue("client_id")}
+	k.mu.Unlock()
+
+	q := redirectURI.Query()
+	q.Set("code", code)
+	q.Set("state", r.FormValue("state"))
+	redirectURI.RawQuery = q.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (k *KTH) token(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	code, ok := k.codes[r.FormValue("code")]
+	delete(k.codes, r.FormValue("code"))
+	k.mu.Unlock()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]any{
+		"iss":         k.URL.String(),
+		"sub":         code.kthid,
+		"aud":         code.clientID,
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Hour).Unix(),
+		"username":    code.kthid,
+		"unique_name": []string{code.name},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: k.key, KeyID: "kth"}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signed, err := signer.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, err := signed.CompactSerialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": uuid.NewString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
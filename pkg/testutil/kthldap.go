@@ -0,0 +1,52 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/kthldap"
+)
+
+type LDAP struct {
+	fake
+	mu     sync.Mutex
+	people map[string]kthldap.Person
+}
+
+// NewLDAP starts a fake ldap proxy which doesn't know about anyone. Use Add to
+// make people exist.
+func NewLDAP(t testing.TB) *LDAP {
+	t.Helper()
+	l := &LDAP{people: make(map[string]kthldap.Person)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", l.user)
+	l.start(t, mux)
+	return l
+}
+
+func (l *LDAP) Add(people ...kthldap.Person) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range people {
+		l.people[p.KTHID] = p
+	}
+}
+
+func (l *LDAP) Client() *kthldap.Client {
+	return kthldap.NewClient(&config.Cfg{LDAPProxyURL: l.URL}, l.HTTPClient())
+}
+
+func (l *LDAP) user(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	p, ok := l.people[r.FormValue("kthid")]
+	l.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
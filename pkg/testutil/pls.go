@@ -0,0 +1,95 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/pls"
+)
+
+type Pls struct {
+	fake
+	mu sync.Mutex
+	// kind ("user" or "token") -> who -> "system.permission"
+	grants map[string]map[string][]string
+	// kthid -> group -> permissions
+	groups map[string]map[string][]string
+}
+
+// NewPls starts a fake pls in which no user or token has any permissions.
+func NewPls(t testing.TB) *Pls {
+	t.Helper()
+	p := &Pls{
+		grants: map[string]map[string][]string{"user": {}, "token": {}},
+		groups: make(map[string]map[string][]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/user/{kthid}/{group}", p.groupPermissions)
+	mux.HandleFunc("GET /api/{kind}/{who}/{system}/{perm}", p.check)
+	p.start(t, mux)
+	return p
+}
+
+// GrantUser gives a user a permission, written as "system.permission".
+func (p *Pls) GrantUser(kthid, permission string) {
+	p.grant("user", kthid, permission)
+}
+
+// GrantToken gives a token a permission, written as "system.permission".
+func (p *Pls) GrantToken(token, permission string) {
+	p.grant("token", token, permission)
+}
+
+// SetGroup sets the permissions a user has in a group.
+func (p *Pls) SetGroup(kthid, group string, perms ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.groups[kthid] == nil {
+		p.groups[kthid] = make(map[string][]string)
+	}
+	p.groups[kthid][group] = perms
+}
+
+func (p *Pls) Client() *pls.Client {
+	return pls.NewClient(&config.Cfg{PlsURL: p.URL}, p.HTTPClient())
+}
+
+func (p *Pls) grant(kind, who, permission string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grants[kind][who] = append(p.grants[kind][who], permission)
+}
+
+func (p *Pls) groupPermissions(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	perms := p.groups[r.PathValue("kthid")][r.PathValue("group")]
+	p.mu.Unlock()
+	if perms == nil {
+		perms = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(perms)
+}
+
+func (p *Pls) check(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	grants, ok := p.grants[r.PathValue("kind")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	who := r.PathValue("who")
+	if _, ok := grants[who]; !ok && r.PathValue("kind") == "token" {
+		// Mimic pls, which fails with 500 for unknown tokens
+		http.Error(w, "No such token", http.StatusInternalServerError)
+		return
+	}
+	has := slices.Contains(grants[who], r.PathValue("system")+"."+r.PathValue("perm"))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(has)
+}
@@ -0,0 +1,67 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/rfinger"
+)
+
+const rfingerAPIKey = "testutil-rfinger-key"
+
+// Rfinger is a fake rfinger which has a picture of everyone. The picture URLs
+// are derived from the kthid and the requested quality.
+type Rfinger struct {
+	fake
+}
+
+func NewRfinger(t testing.TB) *Rfinger {
+	t.Helper()
+	f := &Rfinger{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/{kthid}", f.picture)
+	mux.HandleFunc("POST /api/batch", f.batch)
+	f.start(t, f.requireKey(mux))
+	return f
+}
+
+// PictureURL returns the URL the fake responds with for the given user.
+func (f *Rfinger) PictureURL(kthid string, quality bool) string {
+	return fmt.Sprintf("https://rfinger.example/%s?quality=%t", kthid, quality)
+}
+
+func (f *Rfinger) Client() *rfinger.Client {
+	return rfinger.NewClient(&config.Cfg{RfingerURL: f.URL, RfingerAPIKey: rfingerAPIKey}, f.HTTPClient())
+}
+
+func (f *Rfinger) requireKey(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+rfingerAPIKey {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (f *Rfinger) picture(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte(f.PictureURL(r.PathValue("kthid"), r.FormValue("quality") == "true")))
+}
+
+func (f *Rfinger) batch(w http.ResponseWriter, r *http.Request) {
+	var kthids []string
+	if err := json.NewDecoder(r.Body).Decode(&kthids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quality := r.FormValue("quality") == "true"
+	pictures := make(map[string]string, len(kthids))
+	for _, kthid := range kthids {
+		pictures[kthid] = f.PictureURL(kthid, quality)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(pictures)
+}
@@ -0,0 +1,91 @@
+// Package testutil contains httptest based fakes for the upstream services
+// SSO talks to, so that flows can be exercised without network access. Each
+// fake records the requests it receives and can construct a client from the
+// corresponding package pointing at itself.
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// Call is a request received by a fake.
+type Call struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Recorder keeps track of the requests received by a fake.
+type Recorder struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Calls returns all requests received so far, oldest first.
+func (rec *Recorder) Calls() []Call {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Call(nil), rec.calls...)
+}
+
+// Reset forgets all recorded requests.
+func (rec *Recorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.calls = nil
+}
+
+func (rec *Recorder) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec.mu.Lock()
+		rec.calls = append(rec.calls, Call{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Header: r.Header.Clone(),
+			Body:   body,
+		})
+		rec.mu.Unlock()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// fake is the part shared by all fakes: a recorded httptest server which is
+// closed when the test finishes.
+type fake struct {
+	Recorder
+	URL    *url.URL
+	server *httptest.Server
+}
+
+func (f *fake) start(t testing.TB, h http.Handler) {
+	t.Helper()
+	f.server = httptest.NewServer(f.wrap(h))
+	t.Cleanup(f.server.Close)
+	u, err := url.Parse(f.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.URL = u
+}
+
+// HTTPClient returns a client suitable for talking to the fake.
+func (f *fake) HTTPClient() *http.Client {
+	return f.server.Client()
+}
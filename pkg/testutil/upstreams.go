@@ -0,0 +1,39 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/datasektionen/sso/pkg/config"
+)
+
+// Upstreams bundles one fake of each upstream service.
+type Upstreams struct {
+	Hive    *Hive
+	LDAP    *LDAP
+	Pls     *Pls
+	Rfinger *Rfinger
+	Spam    *Spam
+}
+
+func NewUpstreams(t testing.TB) *Upstreams {
+	t.Helper()
+	return &Upstreams{
+		Hive:    NewHive(t),
+		LDAP:    NewLDAP(t),
+		Pls:     NewPls(t),
+		Rfinger: NewRfinger(t),
+		Spam:    NewSpam(t),
+	}
+}
+
+// Configure points all upstream URLs in the config at the fakes.
+func (u *Upstreams) Configure(cfg *config.Cfg) {
+	cfg.HiveURL = u.Hive.URL
+	cfg.HiveAPIKey = hiveAPIKey
+	cfg.LDAPProxyURL = u.LDAP.URL
+	cfg.PlsURL = u.Pls.URL
+	cfg.RfingerURL = u.Rfinger.URL
+	cfg.RfingerAPIKey = rfingerAPIKey
+	cfg.SpamURL = u.Spam.URL
+	cfg.SpamAPIKey = spamAPIKey
+}
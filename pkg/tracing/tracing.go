@@ -0,0 +1,137 @@
+// Package tracing sets up OpenTelemetry tracing and contains the instrumentation that isn't
+// provided by the libraries themselves.
+//
+// Tracing is off unless $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, in which case spans are
+// created for incoming requests, the handlers they are routed to, database queries and requests
+// to other systems.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/datasektionen/sso/pkg/config"
+)
+
+// Tracer is used for the spans we create ourselves. It's the global tracer provider's, so it
+// doesn't record anything until Init has enabled tracing.
+var Tracer = otel.Tracer("github.com/datasektionen/sso")
+
+// Init sets up the global tracer provider and propagator. The returned function flushes any
+// buffered spans. If tracing isn't configured nothing is exported and the returned function does
+// nothing.
+func Init(ctx context.Context, cfg *config.Cfg) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if cfg.OTLPTracesEndpoint == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPTracesEndpoint.String()))
+	if err != nil {
+		return nil, err
+	}
+	// $OTEL_SERVICE_NAME and $OTEL_RESOURCE_ATTRIBUTES take precedence, since they come last.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("sso")),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The sampler is configured with $OTEL_TRACES_SAMPLER and $OTEL_TRACES_SAMPLER_ARG.
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware creates a span for each request, continuing the trace of the caller if it sent a
+// traceparent header. The span is named after the pattern of the route that handled the request.
+func Middleware(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(nameSpan(h), "",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// nameSpan renames the current span once h has run. It must be outside of the mux but receive
+// the same *http.Request, since that's where the mux puts the pattern.
+func nameSpan(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		if r.Pattern != "" {
+			trace.SpanFromContext(r.Context()).SetName(r.Pattern)
+		}
+	})
+}
+
+// Transport creates a span for each request to the given upstream. Only if propagate is set are
+// the trace headers sent along, since there's no use in leaking them to systems that don't trace.
+func Transport(upstream string, propagate bool, next http.RoundTripper) http.RoundTripper {
+	propagator := propagation.NewCompositeTextMapPropagator()
+	if propagate {
+		propagator = otel.GetTextMapPropagator()
+	}
+	return otelhttp.NewTransport(next,
+		otelhttp.WithPropagators(propagator),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return upstream + " " + r.Method
+		}),
+	)
+}
+
+// QueryTracer creates a span for each database query. The spans are named after the sqlc query,
+// e.g. `GetSession`.
+type QueryTracer struct{}
+
+var _ pgx.QueryTracer = QueryTracer{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	name := queryName(data.SQL)
+	ctx, _ = Tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(name),
+			semconv.DBQueryText(data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
+
+// queryName extracts the name from sqlc's `-- name: GetSession :one` header. Queries that aren't
+// generated by sqlc, e.g. migrations, are all called `query`.
+func queryName(sql string) string {
+	header, _, _ := strings.Cut(sql, "\n")
+	if name, ok := strings.CutPrefix(header, "-- name: "); ok {
+		if name, _, ok := strings.Cut(name, " "); ok {
+			return name
+		}
+	}
+	return "query"
+}
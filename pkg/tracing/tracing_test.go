@@ -0,0 +1,17 @@
+package tracing
+
+import "testing"
+
+func TestQueryName(t *testing.T) {
+	for sql, want := range map[string]string{
+		"-- name: GetSession :one\nselect * from sessions where id = $1": "GetSession",
+		"-- name: DeleteExpiredSessions :exec\ndelete from sessions":     "DeleteExpiredSessions",
+		"-- name: Broken\nselect 1":                                      "query",
+		"create table users (kthid text primary key)":                    "query",
+		"-- a comment\n-- name: GetSession :one\nselect 1":               "query",
+	} {
+		if got := queryName(sql); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/httputil"
+)
+
+// Audit records that whoever is logged in did something to the target. before and after are
+// marshalled to JSON and if they're objects only the fields that differ are stored. Either may be
+// nil, e.g. when something is created or deleted.
+//
+// Failures are logged but otherwise ignored since the action has already been taken by the time
+// this is called.
+func (s *Service) Audit(r *http.Request, action, target string, before, after any) {
+	var actor string
+	if user := s.GetLoggedInUser(r); user != nil {
+		actor = user.KTHID
+	}
+	s.AuditAs(r, actor, action, target, before, after)
+}
+
+// AuditAs is like Audit but with an explicit actor, for requests that aren't logged in (yet). The
+// actor is stored as null if empty.
+//
+// If the request is made in an impersonated session the admin is stored as the actor, and the
+// impersonated user separately.
+func (s *Service) AuditAs(r *http.Request, actor, action, target string, before, after any) {
+	var impersonated string
+	if impersonator := s.GetImpersonator(r); impersonator != "" {
+		impersonated, actor = actor, impersonator
+	}
+	beforeJSON, afterJSON, err := auditDiff(before, after)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Could not marshal audit log values", "action", action, "target", target, "error", err)
+	}
+	// The request may be done (or cancelled) by now, but we still want this stored.
+	ctx := context.WithoutCancel(r.Context())
+	if err := s.DB.InsertAuditLog(ctx, database.InsertAuditLogParams{
+		Actor:        pgtype.Text{String: actor, Valid: actor != ""},
+		Action:       action,
+		Target:       target,
+		Before:       beforeJSON,
+		After:        afterJSON,
+		IpAddress:    httputil.ClientIP(r),
+		Impersonated: pgtype.Text{String: impersonated, Valid: impersonated != ""},
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Could not write to audit log", "actor", actor, "action", action, "target", target, "error", err)
+	}
+}
+
+func auditDiff(before, after any) ([]byte, []byte, error) {
+	var beforeJSON, afterJSON []byte
+	var err error
+	if before != nil {
+		if beforeJSON, err = json.Marshal(before); err != nil {
+			return nil, nil, err
+		}
+	}
+	if after != nil {
+		if afterJSON, err = json.Marshal(after); err != nil {
+			return nil, nil, err
+		}
+	}
+	if beforeJSON == nil || afterJSON == nil {
+		return beforeJSON, afterJSON, nil
+	}
+	var beforeFields, afterFields map[string]json.RawMessage
+	if json.Unmarshal(beforeJSON, &beforeFields) != nil || json.Unmarshal(afterJSON, &afterFields) != nil {
+		return beforeJSON, afterJSON, nil
+	}
+	for field, value := range beforeFields {
+		if other, ok := afterFields[field]; ok && bytes.Equal(value, other) {
+			delete(beforeFields, field)
+			delete(afterFields, field)
+		}
+	}
+	if beforeJSON, err = json.Marshal(beforeFields); err != nil {
+		return nil, nil, err
+	}
+	if afterJSON, err = json.Marshal(afterFields); err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+func DBAuditLogToModel(entry database.AuditLog) models.AuditEntry {
+	return models.AuditEntry{
+		ID:           entry.ID,
+		CreatedAt:    entry.CreatedAt.Time,
+		Actor:        entry.Actor.String,
+		Action:       entry.Action,
+		Target:       entry.Target,
+		Before:       entry.Before,
+		After:        entry.After,
+		IPAddress:    entry.IpAddress,
+		Impersonated: entry.Impersonated.String,
+	}
+}
+
+func (s *Service) ListAuditLog(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	since, until := auditFilterRange(filter)
+	entries, err := s.DB.ListAuditLog(ctx, database.ListAuditLogParams{
+		Limit:  int32(limit),
+		Offset: int32(offset),
+		Actor:  filter.Actor,
+		Action: filter.Action,
+		Target: filter.Target,
+		Since:  since,
+		Until:  until,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dbAuditLogToModels(entries), nil
+}
+
+// ListAuditLogBefore returns up to limit entries matching the filter that come after before, or
+// from the start if it's nil.
+func (s *Service) ListAuditLogBefore(ctx context.Context, filter models.AuditFilter, limit int, before *models.AuditEntry) ([]models.AuditEntry, error) {
+	since, until := auditFilterRange(filter)
+	params := database.ListAuditLogBeforeParams{
+		Actor:    filter.Actor,
+		Action:   filter.Action,
+		Target:   filter.Target,
+		Since:    since,
+		Until:    until,
+		PageSize: int32(limit),
+	}
+	if before != nil {
+		params.BeforeID = pgtype.UUID{Bytes: before.ID, Valid: true}
+		params.BeforeCreatedAt = pgtype.Timestamp{Time: before.CreatedAt, Valid: true}
+	}
+	entries, err := s.DB.ListAuditLogBefore(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return dbAuditLogToModels(entries), nil
+}
+
+func auditFilterRange(filter models.AuditFilter) (since, until pgtype.Timestamp) {
+	if !filter.Since.IsZero() {
+		since = pgtype.Timestamp{Time: filter.Since, Valid: true}
+	}
+	if !filter.Until.IsZero() {
+		until = pgtype.Timestamp{Time: filter.Until.AddDate(0, 0, 1), Valid: true}
+	}
+	return since, until
+}
+
+func dbAuditLogToModels(entries []database.AuditLog) []models.AuditEntry {
+	res := make([]models.AuditEntry, len(entries))
+	for i, entry := range entries {
+		res[i] = DBAuditLogToModel(entry)
+	}
+	return res
+}
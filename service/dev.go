@@ -5,12 +5,11 @@ import (
 	"io"
 
 	"github.com/a-h/templ"
-	"github.com/datasektionen/sso/pkg/config"
 	"github.com/datasektionen/sso/templates"
 )
 
 func (s *Service) DevLoginFormOrNilComp() templ.Component {
-	if !config.Config.Dev {
+	if !s.Cfg.Dev {
 		return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
 			return nil
 		})
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/models"
+	"github.com/datasektionen/sso/pkg/auth"
+	"github.com/datasektionen/sso/pkg/httputil"
+)
+
+// GetImpersonator returns the kthid of the admin impersonating the logged in user, or "" if the
+// request isn't made in an impersonated session.
+func (s *Service) GetImpersonator(r *http.Request) string {
+	impersonator, _ := r.Context().Value(models.ImpersonatorCtxKey{}).(string)
+	return impersonator
+}
+
+// Impersonate switches the logged in admin over to a new session in which they're logged in as
+// the given user. The admin's own session is kept and is switched back to by StopImpersonating.
+func (s *Service) Impersonate(r *http.Request, kthid string) httputil.ToResponse {
+	admin := s.GetLoggedInUser(r)
+	if admin == nil {
+		return httputil.Unauthorized()
+	}
+	if s.GetImpersonator(r) != "" {
+		return httputil.BadRequest("Already impersonating someone")
+	}
+	if admin.KTHID == kthid {
+		return httputil.BadRequest("You can't impersonate yourself")
+	}
+	sessionID, err := s.DB.CreateImpersonationSession(r.Context(), database.CreateImpersonationSessionParams{
+		Kthid:                 pgtype.Text{String: kthid, Valid: true},
+		UserAgent:             r.UserAgent(),
+		IpAddress:             httputil.ClientIP(r),
+		Impersonator:          pgtype.Text{String: admin.KTHID, Valid: true},
+		ImpersonatorSessionID: pgtype.UUID{Bytes: s.CurrentSessionID(r), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	s.Audit(r, "impersonation.start", kthid, nil, map[string]any{"session": sessionID})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, maxAge := s.sessionLifetime(false)
+		http.SetCookie(w, auth.SessionCookie(s.Cfg, sessionID.String(), time.Now().Add(maxAge)))
+		httputil.Respond(httputil.Redirect("/account"), w, r)
+	})
+}
+
+// StopImpersonating removes the impersonated session and switches back to the admin's own session,
+// if that's still around.
+func (s *Service) StopImpersonating(w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	adminSessionID, err := s.DB.EndImpersonation(r.Context(), s.CurrentSessionID(r))
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		if user := s.GetLoggedInUser(r); user != nil {
+			s.Audit(r, "impersonation.stop", user.KTHID, nil, nil)
+		}
+	}
+	var secondsLeft int32
+	if adminSessionID.Valid {
+		secondsLeft, err = s.DB.GetSessionSecondsLeft(r.Context(), database.GetSessionSecondsLeftParams{
+			RememberedMaxAge: int32(s.Cfg.RememberedSessionMaxAge.Seconds()),
+			MaxAge:           int32(s.Cfg.SessionMaxAge.Seconds()),
+			ID:               adminSessionID.Bytes,
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			return err
+		}
+	}
+	if secondsLeft <= 0 {
+		http.SetCookie(w, &http.Cookie{Name: auth.SessionCookieName, MaxAge: -1, Path: "/"})
+		return httputil.Redirect("/")
+	}
+	http.SetCookie(w, auth.SessionCookie(
+		s.Cfg,
+		uuid.UUID(adminSessionID.Bytes).String(),
+		time.Now().Add(time.Duration(secondsLeft)*time.Second),
+	))
+	return httputil.Redirect("/admin/members")
+}
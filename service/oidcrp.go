@@ -11,7 +11,7 @@ import (
 	oidcHttp "github.com/zitadel/oidc/v3/pkg/http"
 )
 
-func initRP(ctx context.Context) (rp.RelyingParty, error) {
+func initRP(ctx context.Context, cfg *config.Cfg) (rp.RelyingParty, error) {
 	// TODO: persist?
 	hashKey := make([]byte, 32)
 	if _, err := rand.Read(hashKey); err != nil {
@@ -23,11 +23,12 @@ func initRP(ctx context.Context) (rp.RelyingParty, error) {
 	}
 	rp, err := rp.NewRelyingPartyOIDC(
 		ctx,
-		config.Config.KTHOIDCIssuerURL.String(),
-		config.Config.KTHOIDCClientID,
-		config.Config.KTHOIDCClientSecret,
-		config.Config.KTHOIDCRPOrigin.String()+"/oidc/kth/callback",
+		cfg.KTHOIDCIssuerURL.String(),
+		cfg.KTHOIDCClientID,
+		cfg.KTHOIDCClientSecret,
+		cfg.KTHOIDCRPOrigin.String()+"/oidc/kth/callback",
 		[]string{"openid", "profile", "email"},
+		rp.WithHTTPClient(upstreamClient("kth", false)),
 		rp.WithCookieHandler(oidcHttp.NewCookieHandler(
 			hashKey,
 			encryptKey,
@@ -35,7 +36,7 @@ func initRP(ctx context.Context) (rp.RelyingParty, error) {
 		)),
 	)
 	if err != nil {
-		slog.Error("Error initializing relying party for KTH's OIDC provider", "error", err)
+		slog.ErrorContext(ctx, "Error initializing relying party for KTH's OIDC provider", "error", err)
 		rp = nil // Should already be the case but doesn't hurt to make sure
 	}
 	return rp, nil
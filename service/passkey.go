@@ -2,23 +2,183 @@ package service
 
 import (
 	"context"
+	"crypto/x509"
+	_ "embed"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/go-webauthn/webauthn/metadata"
+	"github.com/go-webauthn/webauthn/metadata/providers/cached"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 
 	"github.com/datasektionen/sso/database"
 	"github.com/datasektionen/sso/models"
 	"github.com/datasektionen/sso/pkg/config"
-	"github.com/go-webauthn/webauthn/webauthn"
-	"github.com/jackc/pgx/v5"
+	"github.com/datasektionen/sso/pkg/httputil"
 )
 
-func initWebAuthn() (*webauthn.WebAuthn, error) {
+func initWebAuthn(cfg *config.Cfg) (*webauthn.WebAuthn, error) {
 	return webauthn.New(&webauthn.Config{
-		RPID:          config.Config.Origin.Hostname(),
-		RPDisplayName: "Konglig Datasektionen",
-		RPOrigins:     []string{config.Config.Origin.String()},
+		RPID:                  cfg.Origin.Hostname(),
+		RPDisplayName:         "Konglig Datasektionen",
+		RPOrigins:             []string{cfg.Origin.String()},
+		AttestationPreference: protocol.ConveyancePreference(cfg.WebAuthnAttestation),
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
 	})
 }
 
+// initMDS loads the FIDO metadata service, which has the root certificates that attestation for
+// the authenticators in $WEBAUTHN_ADMIN_AAGUIDS must chain up to. It's only needed for those, so
+// it's nil if they aren't set.
+func initMDS(cfg *config.Cfg) (metadata.Provider, error) {
+	if len(cfg.WebAuthnAdminAAGUIDs) == 0 {
+		return nil, nil
+	}
+	return cached.New(
+		cached.WithPath(cfg.WebAuthnMDSPath),
+		cached.WithClient(upstreamClient("fido-mds", false)),
+	)
+}
+
+// CheckPasskeyAllowed returns an error if the user is an admin and the passkey wasn't attested to
+// be from an authenticator in $WEBAUTHN_ADMIN_AAGUIDS. Other login methods don't check this.
+func (s *Service) CheckPasskeyAllowed(ctx context.Context, kthid string, cred *webauthn.Credential) error {
+	if len(s.Cfg.WebAuthnAdminAAGUIDs) == 0 {
+		return nil
+	}
+	perms, err := s.Hive.GetSSOPermissions(ctx, kthid)
+	if err != nil {
+		return err
+	}
+	if !perms.Any() {
+		return nil
+	}
+	if err := s.verifyAdminAttestation(ctx, cred); err != nil {
+		slog.InfoContext(ctx, "Rejected admin passkey", "kthid", kthid, "error", err)
+		return httputil.Forbidden("Since you're an admin, you must use one of the approved security keys")
+	}
+	return nil
+}
+
+// verifyAdminAttestation checks that the attestation stored with the credential is signed by a
+// certificate that chains up to the root certificates in the metadata service for one of
+// $WEBAUTHN_ADMIN_AAGUIDS. It's checked on every login and not just when the passkey is added,
+// since the user may not have been an admin back then.
+//
+// go-webauthn accepts the formats "none" and self attestation, where nothing vouches for the
+// AAGUID, and doesn't check the chain of self-issued certificates, so that's done here.
+func (s *Service) verifyAdminAttestation(ctx context.Context, cred *webauthn.Credential) error {
+	if s.MDS == nil {
+		return errors.New("the metadata service isn't loaded")
+	}
+	raw := protocol.AuthenticatorAttestationResponse{
+		AuthenticatorResponse: protocol.AuthenticatorResponse{ClientDataJSON: cred.Attestation.ClientDataJSON},
+		AuthenticatorData:     cred.Attestation.AuthenticatorData,
+		PublicKey:             cred.PublicKey,
+		PublicKeyAlgorithm:    cred.Attestation.PublicKeyAlgorithm,
+		AttestationObject:     cred.Attestation.Object,
+	}
+	parsed, err := raw.Parse()
+	if err != nil {
+		return err
+	}
+	att := parsed.AttestationObject
+	aaguid, err := uuid.FromBytes(att.AuthData.AttData.AAGUID)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(s.Cfg.WebAuthnAdminAAGUIDs, aaguid) {
+		return fmt.Errorf("authenticator %s is not allowed", aaguid)
+	}
+	x5c, _ := att.AttStatement["x5c"].([]any)
+	if att.Format == string(protocol.AttestationFormatNone) || len(x5c) == 0 {
+		return fmt.Errorf("attestation format %q has no certificate", att.Format)
+	}
+	// Checks the signature, and that the authenticator hasn't been revoked.
+	if err := att.VerifyAttestation(cred.Attestation.ClientDataHash, s.MDS); err != nil {
+		// The message is often empty, with the reason only in DevInfo.
+		var protoErr *protocol.Error
+		if errors.As(err, &protoErr) {
+			return fmt.Errorf("invalid attestation: %s %s", protoErr.Details, protoErr.DevInfo)
+		}
+		return err
+	}
+	entry, err := s.MDS.GetEntry(ctx, aaguid)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("authenticator %s is not in the metadata service", aaguid)
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, c := range x5c {
+		der, ok := c.([]byte)
+		if !ok {
+			return errors.New("invalid certificate in attestation")
+		}
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return err
+		}
+	}
+	opts := entry.MetadataStatement.Verifier(certs[1:])
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	_, err = certs[0].Verify(opts)
+	return err
+}
+
+var (
+	// Names of passkey providers and security keys, taken from
+	// https://github.com/passkeydeveloper/passkey-authenticator-aaguids and the FIDO metadata
+	// service. Only the most common ones are included.
+	//go:embed passkey_aaguids.json
+	aaguidsRaw []byte
+	aaguids    map[uuid.UUID]string
+)
+
+func init() {
+	if err := json.Unmarshal(aaguidsRaw, &aaguids); err != nil {
+		panic(err)
+	}
+}
+
+// authenticatorName returns the model of the authenticator identified by the AAGUID, or "" if it's
+// unknown. Some authenticators, such as older versions of iCloud Keychain, only send zeros.
+func authenticatorName(aaguid []byte) string {
+	id, err := uuid.FromBytes(aaguid)
+	if err != nil {
+		return ""
+	}
+	return aaguids[id]
+}
+
+func dbPasskeyToModel(passkey database.Passkey) (models.Passkey, error) {
+	var c webauthn.Credential
+	if err := json.Unmarshal(passkey.Data, &c); err != nil {
+		return models.Passkey{}, err
+	}
+	return models.Passkey{
+		ID:            passkey.ID,
+		Name:          passkey.Name,
+		Cred:          c,
+		Kthid:         passkey.Kthid,
+		Discoverable:  passkey.Discoverable,
+		CloneWarning:  c.Authenticator.CloneWarning,
+		Authenticator: authenticatorName(c.Authenticator.AAGUID),
+		CreatedAt:     passkey.CreatedAt.Time,
+		LastUsedAt:    passkey.LastUsedAt.Time,
+	}, nil
+}
+
 func (s *Service) ListPasskeysForUser(ctx context.Context, kthid string) ([]models.Passkey, error) {
 	dbPasskeys, err := s.DB.ListPasskeysByUser(ctx, kthid)
 	if err != nil {
@@ -26,16 +186,10 @@ func (s *Service) ListPasskeysForUser(ctx context.Context, kthid string) ([]mode
 	}
 	passkeys := make([]models.Passkey, len(dbPasskeys))
 	for i, passkey := range dbPasskeys {
-		var c webauthn.Credential
-		if err := json.Unmarshal(passkey.Data, &c); err != nil {
+		passkeys[i], err = dbPasskeyToModel(passkey)
+		if err != nil {
 			return nil, err
 		}
-		passkeys[i] = models.Passkey{
-			ID:           passkey.ID,
-			Name:         passkey.Name,
-			Cred:         c,
-			Discoverable: passkey.Discoverable,
-		}
 	}
 	return passkeys, nil
 }
@@ -51,14 +205,35 @@ func (s *Service) GetPasskey(ctx context.Context, kthid string, rawID []byte) (*
 	if err != nil {
 		return nil, err
 	}
-	var c webauthn.Credential
-	if err := json.Unmarshal(passkey.Data, &c); err != nil {
+	p, err := dbPasskeyToModel(passkey)
+	if err != nil {
 		return nil, err
 	}
-	return &models.Passkey{
-		ID:           passkey.ID,
-		Name:         passkey.Name,
-		Cred:         c,
-		Discoverable: passkey.Discoverable,
-	}, nil
+	return &p, nil
+}
+
+// RecordPasskeyUse stores the credential returned after a successful login, which has an updated
+// sign count, and when it was used.
+//
+// If the sign count didn't increase, the library sets a clone warning on the credential since
+// someone may have copied the private key out of the authenticator. The login is still allowed
+// (there are authenticators with buggy counters), but the passkey is flagged on the account page
+// and it's written to the audit log.
+func (s *Service) RecordPasskeyUse(r *http.Request, passkey models.Passkey, cred *webauthn.Credential) error {
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	if err := s.DB.RecordPasskeyUse(r.Context(), database.RecordPasskeyUseParams{ID: passkey.ID, Data: data}); err != nil {
+		return err
+	}
+	if cred.Authenticator.CloneWarning && !passkey.Cred.Authenticator.CloneWarning {
+		slog.WarnContext(r.Context(), "Passkey sign count did not increase, it may be cloned", "passkey", passkey.ID, "sign_count", cred.Authenticator.SignCount)
+		s.AuditAs(r, passkey.Kthid, "passkey.clone-warning", passkey.Kthid, nil, map[string]any{
+			"passkey":    passkey.ID,
+			"name":       passkey.Name,
+			"sign_count": cred.Authenticator.SignCount,
+		})
+	}
+	return nil
 }
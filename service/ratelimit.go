@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/httputil"
+)
+
+// RateLimit counts a request against the named policy for key, which identifies the client or the
+// account it's about, and returns a 429 error if the limit has been exceeded. An empty key isn't
+// limited.
+//
+// The first request over the limit in each window raises an alert: it's logged, written to the
+// audit log and, if configured, emailed.
+func (s *Service) RateLimit(r *http.Request, name string, policy config.RateLimit, key string) error {
+	if policy.Limit <= 0 || key == "" {
+		return nil
+	}
+	count, left, err := s.RateLimits.Hit(r.Context(), name+":"+key, policy.Window)
+	if err != nil {
+		// Better to let people log in than to lock everyone out when the database has trouble.
+		slog.ErrorContext(r.Context(), "Could not check rate limit", "policy", name, "key", key, "error", err)
+		return nil
+	}
+	if count <= policy.Limit {
+		return nil
+	}
+	if count == policy.Limit+1 {
+		s.rateLimitAlert(r, name, policy, key)
+	}
+	return httputil.TooManyRequests(left)
+}
+
+func (s *Service) rateLimitAlert(r *http.Request, name string, policy config.RateLimit, key string) {
+	slog.WarnContext(r.Context(), "Rate limit exceeded", "policy", name, "limit", policy, "key", key, "path", r.URL.Path, "ip", httputil.ClientIP(r))
+	s.AuditAs(r, "", "rate-limit.exceed", key, nil, map[string]any{
+		"policy": name,
+		"limit":  policy.String(),
+		"path":   r.URL.Path,
+	})
+
+	if s.Cfg.RateLimitAlertEmail == "" {
+		return
+	}
+	// Someone trying many IPs or accounts shouldn't flood the inbox, so only one email is sent per
+	// policy and window.
+	ctx := context.WithoutCancel(r.Context())
+	if count, _, err := s.RateLimits.Hit(ctx, "alert:"+name, policy.Window); err != nil || count > 1 {
+		return
+	}
+	if err := s.Email.Send(
+		ctx,
+		s.Cfg.RateLimitAlertEmail,
+		"SSO - Rate limit exceeded",
+		fmt.Sprintf(
+			"The rate limit `%s` (%s) was exceeded by `%s` on `%s`. Further alerts for this limit are "+
+				"suppressed for %s, see the audit log for all of them.",
+			name, policy, key, r.URL.Path, policy.Window,
+		),
+	); err != nil {
+		slog.ErrorContext(r.Context(), "Could not send rate limit alert", "error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/pkg/httputil"
+)
+
+const recoveryCodeCount = 10
+
+// normalizeCode removes what people tend to add or get wrong when typing a code.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// hashRecoveryCode hashes a recovery code for storage. The codes have 80 bits of entropy, so unlike
+// passwords they don't need a slow hash.
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(normalizeCode(code)))
+	return hash[:]
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes with new ones. Only hashes are stored,
+// so this is the only time the codes can be shown.
+func (s *Service) GenerateRecoveryCodes(r *http.Request, kthid string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	err := s.DB.Tx(r.Context(), func(db *database.Queries) error {
+		if err := db.RemoveRecoveryCodes(r.Context(), kthid); err != nil {
+			return err
+		}
+		for i := range codes {
+			var raw [10]byte
+			if _, err := rand.Read(raw[:]); err != nil {
+				return err
+			}
+			code := base32.StdEncoding.EncodeToString(raw[:])
+			codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+			if err := db.AddRecoveryCode(r.Context(), database.AddRecoveryCodeParams{
+				Kthid:    kthid,
+				CodeHash: hashRecoveryCode(code),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Audit(r, "recovery-codes.generate", kthid, nil, nil)
+	return codes, nil
+}
+
+// UseRecoveryCode marks the code as used and returns true if it is one of the user's unused
+// recovery codes. The user is notified by email, so that they notice if someone else got hold of
+// their codes.
+func (s *Service) UseRecoveryCode(r *http.Request, kthid, code string) (bool, error) {
+	n, err := s.DB.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+		Kthid:    kthid,
+		CodeHash: hashRecoveryCode(code),
+	})
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	left, err := s.DB.CountRecoveryCodes(r.Context(), kthid)
+	if err != nil {
+		return false, err
+	}
+	s.AuditAs(r, kthid, "recovery-code.use", kthid, nil, map[string]any{"left": left})
+
+	user, err := s.GetUser(r.Context(), kthid)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, nil
+	}
+	if err := s.Email.Send(r.Context(), user.Email, "SSO - Recovery code used", strings.TrimSpace(fmt.Sprintf(`
+A recovery code was used to log in to your account on [Datasektionen SSO](%s) at %s from %s. You have %d unused recovery codes left.
+
+If this wasn't you, log in and generate new recovery codes on your account page, and contact d-sys@datasektionen.se.
+	`, s.Cfg.Origin, time.Now().Format("2006-01-02 15:04"), httputil.ClientIP(r), left))); err != nil {
+		// The code is used up already, so the user should still get in.
+		slog.ErrorContext(r.Context(), "Could not send recovery code notification", "kthid", kthid, "error", err)
+	}
+	return true, nil
+}
@@ -2,30 +2,82 @@ package service
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/pkg/config"
+	"github.com/datasektionen/sso/pkg/email"
+	"github.com/datasektionen/sso/pkg/hive"
+	"github.com/datasektionen/sso/pkg/jobs"
+	"github.com/datasektionen/sso/pkg/kthldap"
+	"github.com/datasektionen/sso/pkg/metrics"
+	"github.com/datasektionen/sso/pkg/pls"
+	"github.com/datasektionen/sso/pkg/ratelimit"
+	"github.com/datasektionen/sso/pkg/rfinger"
+	"github.com/datasektionen/sso/pkg/tracing"
+	"github.com/go-webauthn/webauthn/metadata"
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/zitadel/oidc/v3/pkg/client/rp"
 )
 
 type Service struct {
+	Cfg          *config.Cfg
 	DB           *database.Queries
 	WebAuthn     *webauthn.WebAuthn
+	MDS          metadata.Provider // Only set if Cfg.WebAuthnAdminAAGUIDs is.
 	RelyingParty rp.RelyingParty
+	// Jobs is not started by NewService.
+	Jobs       *jobs.Scheduler
+	RateLimits ratelimit.Store
+
+	// Clients for upstream services. These are constructed from Cfg by
+	// NewService but may be replaced, e.g. to use a different http.Client.
+	Hive    *hive.Client
+	LDAP    *kthldap.Client
+	Pls     *pls.Client
+	Rfinger *rfinger.Client
+	Email   *email.Client
 }
 
-func NewService(ctx context.Context, db *database.Queries) (*Service, error) {
-	wa, err := initWebAuthn()
+func NewService(ctx context.Context, cfg *config.Cfg, db *database.Queries) (*Service, error) {
+	wa, err := initWebAuthn(cfg)
+	if err != nil {
+		return nil, err
+	}
+	mds, err := initMDS(cfg)
 	if err != nil {
 		return nil, err
 	}
-	rp, err := initRP(ctx)
+	rp, err := initRP(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
+	var rateLimits ratelimit.Store = ratelimit.NewPostgres(db)
+	if cfg.RateLimitStore == "memory" {
+		rateLimits = ratelimit.NewMemory()
+	}
 	return &Service{
+		Cfg:          cfg,
 		DB:           db,
 		WebAuthn:     wa,
+		MDS:          mds,
 		RelyingParty: rp,
+		Jobs:         jobs.NewScheduler(db, jobs.Cleanup(cfg)...),
+		RateLimits:   rateLimits,
+
+		Hive:    hive.NewClient(cfg, upstreamClient("hive", true)),
+		LDAP:    kthldap.NewClient(cfg, upstreamClient("ldap", false)),
+		Pls:     pls.NewClient(cfg, upstreamClient("pls", false)),
+		Rfinger: rfinger.NewClient(cfg, upstreamClient("rfinger", true)),
+		Email:   email.NewClient(cfg, upstreamClient("spam", false)),
 	}, nil
 }
+
+// upstreamClient records metrics and traces for requests to the named upstream. Trace context is
+// only propagated to our own systems, which are the only ones that could do anything with it.
+func upstreamClient(upstream string, propagate bool) *http.Client {
+	return &http.Client{Transport: metrics.Transport(
+		upstream,
+		tracing.Transport(upstream, propagate, http.DefaultTransport),
+	)}
+}
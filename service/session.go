@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/models"
+)
+
+// CurrentSessionID returns the id of the session the request was made in, or uuid.Nil if there is
+// none.
+func (s *Service) CurrentSessionID(r *http.Request) uuid.UUID {
+	id, _ := r.Context().Value(models.SessionIDCtxKey{}).(uuid.UUID)
+	return id
+}
+
+// ListSessions returns the user's active sessions, most recently used first. The session with id
+// `current` is marked as such.
+func (s *Service) ListSessions(ctx context.Context, kthid string, current uuid.UUID) ([]models.Session, error) {
+	rows, err := s.DB.ListSessionsForUser(ctx, database.ListSessionsForUserParams{
+		Kthid:                 pgtype.Text{String: kthid, Valid: true},
+		RememberedIdleTimeout: int32(s.Cfg.RememberedSessionIdleTimeout.Seconds()),
+		IdleTimeout:           int32(s.Cfg.SessionIdleTimeout.Seconds()),
+		RememberedMaxAge:      int32(s.Cfg.RememberedSessionMaxAge.Seconds()),
+		MaxAge:                int32(s.Cfg.SessionMaxAge.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]models.Session, len(rows))
+	for i, row := range rows {
+		sessions[i] = models.Session{
+			ID:           row.ID,
+			CreatedAt:    row.CreatedAt.Time,
+			LastUsedAt:   row.LastUsedAt.Time,
+			LoginMethod:  models.LoginMethod(row.LoginMethod),
+			UserAgent:    row.UserAgent,
+			IPAddress:    row.IpAddress,
+			Remembered:   row.Remember,
+			Impersonator: row.Impersonator.String,
+			Current:      row.ID == current,
+		}
+	}
+	return sessions, nil
+}
+
+// RemoveUserSession removes one of the user's sessions. Returns false if the user has no session with
+// that id.
+func (s *Service) RemoveUserSession(ctx context.Context, kthid string, id uuid.UUID) (bool, error) {
+	n, err := s.DB.RemoveSessionForUser(ctx, database.RemoveSessionForUserParams{
+		ID:    id,
+		Kthid: pgtype.Text{String: kthid, Valid: true},
+	})
+	return n > 0, err
+}
+
+// RemoveUserSessions logs the user out everywhere.
+func (s *Service) RemoveUserSessions(ctx context.Context, kthid string) error {
+	return s.DB.RemoveSessionsForUser(ctx, pgtype.Text{String: kthid, Valid: true})
+}
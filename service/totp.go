@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/datasektionen/sso/database"
+	"github.com/datasektionen/sso/models"
+)
+
+const (
+	totpIssuer = "Datasektionen SSO"
+	totpPeriod = 30
+	// After this many failed attempts in a row, logging in with TOTP is blocked for totpLockout.
+	totpMaxAttempts = 5
+	totpLockout     = 15 * time.Minute
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("An authenticator app is already set up")
+	ErrTOTPNotEnabled     = errors.New("No authenticator app is set up")
+	ErrTOTPInvalid        = errors.New("Invalid code")
+	ErrTOTPLocked         = errors.New("Too many invalid codes, please try again later")
+)
+
+func (s *Service) GetTOTPStatus(ctx context.Context, kthid string) (models.TOTPStatus, error) {
+	secret, err := s.DB.GetTOTP(ctx, kthid)
+	if err == pgx.ErrNoRows || (err == nil && !secret.ConfirmedAt.Valid) {
+		return models.TOTPStatus{}, nil
+	}
+	if err != nil {
+		return models.TOTPStatus{}, err
+	}
+	return models.TOTPStatus{
+		Enabled:   true,
+		EnabledAt: secret.ConfirmedAt.Time,
+	}, nil
+}
+
+// totpKey returns the key for a base32 encoded secret, which contains the otpauth:// URL that
+// authenticator apps scan.
+func totpKey(kthid, secret string) (*otp.Key, error) {
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: kthid,
+		Period:      totpPeriod,
+		Secret:      raw,
+	})
+}
+
+// BeginTOTPEnrollment generates a new secret for the user, which is not used for logging in until
+// it's confirmed with ConfirmTOTP. Calling this again before that replaces the secret.
+func (s *Service) BeginTOTPEnrollment(ctx context.Context, kthid string) (*otp.Key, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: kthid,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.DB.BeginTOTPEnrollment(ctx, database.BeginTOTPEnrollmentParams{
+		Kthid:  kthid,
+		Secret: key.Secret(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	return key, nil
+}
+
+// PendingTOTPKey returns the key from BeginTOTPEnrollment, or nil if there is none.
+func (s *Service) PendingTOTPKey(ctx context.Context, kthid string) (*otp.Key, error) {
+	secret, err := s.DB.GetTOTP(ctx, kthid)
+	if err == pgx.ErrNoRows || (err == nil && secret.ConfirmedAt.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return totpKey(kthid, secret.Secret)
+}
+
+// totpStep returns the time step the code belongs to, allowing for one step of clock skew in
+// either direction, or -1 if the code is wrong.
+func totpStep(secret, code string, now time.Time) int64 {
+	for _, skew := range []int64{0, -1, 1} {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && expected == code {
+			return t.Unix() / totpPeriod
+		}
+	}
+	return -1
+}
+
+// ConfirmTOTP enables TOTP for the user if the code matches the pending secret.
+func (s *Service) ConfirmTOTP(ctx context.Context, kthid, code string) error {
+	secret, err := s.DB.GetTOTP(ctx, kthid)
+	if err == pgx.ErrNoRows {
+		return ErrTOTPNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if secret.ConfirmedAt.Valid {
+		return ErrTOTPAlreadyEnabled
+	}
+	step := totpStep(secret.Secret, normalizeCode(code), time.Now())
+	if step < 0 {
+		return ErrTOTPInvalid
+	}
+	_, err = s.DB.ConfirmTOTP(ctx, database.ConfirmTOTPParams{Kthid: kthid, LastUsedStep: step})
+	return err
+}
+
+// RemoveTOTP disables TOTP for the user. Returns false if it wasn't enabled.
+func (s *Service) RemoveTOTP(ctx context.Context, kthid string) (bool, error) {
+	n, err := s.DB.RemoveTOTP(ctx, kthid)
+	return n > 0, err
+}
+
+// VerifyTOTPLogin checks a code from the user's authenticator app.
+func (s *Service) VerifyTOTPLogin(ctx context.Context, kthid, code string) error {
+	row, err := s.DB.GetTOTPForLogin(ctx, database.GetTOTPForLoginParams{
+		MaxAttempts:    totpMaxAttempts,
+		LockoutSeconds: int32(totpLockout.Seconds()),
+		Kthid:          kthid,
+	})
+	if err == pgx.ErrNoRows {
+		return ErrTOTPNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if row.Locked {
+		return ErrTOTPLocked
+	}
+	if step := totpStep(row.Secret, normalizeCode(code), time.Now()); step >= 0 {
+		// If the step isn't newer than the last one used, the code has been used already.
+		if n, err := s.DB.RecordTOTPUse(ctx, database.RecordTOTPUseParams{Kthid: kthid, LastUsedStep: step}); err != nil {
+			return err
+		} else if n > 0 {
+			return nil
+		}
+	}
+	if err := s.DB.RecordTOTPFailure(ctx, kthid); err != nil {
+		return err
+	}
+	return ErrTOTPInvalid
+}
@@ -11,16 +11,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
 	"github.com/datasektionen/sso/database"
 	"github.com/datasektionen/sso/models"
 	"github.com/datasektionen/sso/pkg/auth"
-	"github.com/datasektionen/sso/pkg/email"
+	"github.com/datasektionen/sso/pkg/config"
 	"github.com/datasektionen/sso/pkg/hive"
 	"github.com/datasektionen/sso/pkg/httputil"
-	"github.com/datasektionen/sso/pkg/kthldap"
-	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/datasektionen/sso/pkg/logging"
+	"github.com/datasektionen/sso/pkg/metrics"
 )
 
 func DBUserToModel(user database.User) models.User {
@@ -82,7 +84,7 @@ func (s *Service) UserSetNameChangeRequest(ctx context.Context, user models.User
 	if err != nil {
 		return models.User{}, err
 	}
-	if err := email.Send(ctx, "d-sys@datasektionen.se", "SSO - Name change requested", strings.TrimSpace(`
+	if err := s.Email.Send(ctx, "d-sys@datasektionen.se", "SSO - Name change requested", strings.TrimSpace(`
 The user `+user.FirstName+` `+user.FamilyName+` (`+user.KTHID+`) has requested to change their name to `+"`"+firstName+"`"+` `+"`"+familyName+"`"+`.
 	`)); err != nil {
 		return models.User{}, err
@@ -90,25 +92,46 @@ The user `+user.FirstName+` `+user.FamilyName+` (`+user.KTHID+`) has requested t
 	return DBUserToModel(newUser), nil
 }
 
-func (s *Service) LoginUser(ctx context.Context, kthid string, redirect bool) httputil.ToResponse {
-	perms, err := hive.GetSSOPermissions(ctx, kthid)
-	if err != nil {
-		return err
-	}
+func marshalPermissions(perms hive.Permissions) []byte {
 	jsonPerms, err := json.Marshal(perms)
 	if err != nil {
-		slog.Error("LoginUser: could not marshal permissions. Resorting to empty permissions", "permissions", perms, "error", err)
+		slog.Error("Could not marshal permissions. Resorting to empty permissions", "permissions", perms, "error", err)
 		jsonPerms = []byte(`{}`)
 	}
-	sessionID, err := s.DB.CreateSession(ctx, database.CreateSessionParams{
+	return jsonPerms
+}
+
+// sessionLifetime returns how long a session may be idle and how long it may live at most.
+func (s *Service) sessionLifetime(remember bool) (idleTimeout, maxAge time.Duration) {
+	if remember {
+		return s.Cfg.RememberedSessionIdleTimeout, s.Cfg.RememberedSessionMaxAge
+	}
+	return s.Cfg.SessionIdleTimeout, s.Cfg.SessionMaxAge
+}
+
+func (s *Service) LoginUser(r *http.Request, kthid string, method models.LoginMethod, redirect bool) httputil.ToResponse {
+	perms, err := s.Hive.GetSSOPermissions(r.Context(), kthid)
+	if err != nil {
+		return err
+	}
+	jsonPerms := marshalPermissions(perms)
+	remember := auth.WantsToBeRemembered(r)
+	sessionID, err := s.DB.CreateSession(r.Context(), database.CreateSessionParams{
 		Kthid:       pgtype.Text{String: kthid, Valid: true},
 		Permissions: jsonPerms,
+		LoginMethod: string(method),
+		UserAgent:   r.UserAgent(),
+		IpAddress:   httputil.ClientIP(r),
+		Remember:    remember,
 	})
 	if err != nil {
 		return err
 	}
+	s.AuditAs(r, kthid, "login", kthid, nil, map[string]any{"method": method, "session": sessionID})
+	metrics.LoginSucceeded(method)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, auth.SessionCookie(sessionID.String()))
+		_, maxAge := s.sessionLifetime(remember)
+		http.SetCookie(w, auth.SessionCookie(s.Cfg, sessionID.String(), time.Now().Add(maxAge)))
 		if redirect {
 			httputil.Respond(httputil.Redirect("/"), w, r)
 		} else {
@@ -117,20 +140,29 @@ func (s *Service) LoginUser(ctx context.Context, kthid string, redirect bool) ht
 	})
 }
 
-func (s *Service) LoginGuestUser(ctx context.Context, guestUser models.GuestUser, redirect bool) httputil.ToResponse {
+// LoginGuestUser creates a session for someone who logged in with KTH but has no account.
+func (s *Service) LoginGuestUser(r *http.Request, guestUser models.GuestUser, redirect bool) httputil.ToResponse {
 	guestData, err := json.Marshal(guestUser)
 	if err != nil {
 		return err
 	}
-	sessionID, err := s.DB.CreateGuestSession(ctx, database.CreateGuestSessionParams{
+	remember := auth.WantsToBeRemembered(r)
+	sessionID, err := s.DB.CreateGuestSession(r.Context(), database.CreateGuestSessionParams{
 		GuestData:   guestData,
 		Permissions: []byte(`{}`),
+		LoginMethod: string(models.LoginMethodKTH),
+		UserAgent:   r.UserAgent(),
+		IpAddress:   httputil.ClientIP(r),
+		Remember:    remember,
 	})
 	if err != nil {
 		return err
 	}
+	s.AuditAs(r, "", "guest-login", guestUser.KTHID, nil, map[string]any{"session": sessionID})
+	metrics.LoginSucceeded(models.LoginMethodKTH)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, auth.SessionCookie(sessionID.String()))
+		_, maxAge := s.sessionLifetime(remember)
+		http.SetCookie(w, auth.SessionCookie(s.Cfg, sessionID.String(), time.Now().Add(maxAge)))
 		if redirect {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 		} else {
@@ -140,6 +172,9 @@ func (s *Service) LoginGuestUser(ctx context.Context, guestUser models.GuestUser
 }
 
 func (s *Service) WithSession(r *http.Request) (*http.Request, error) {
+	// Templates get the config from the context
+	r = r.WithContext(config.NewContext(r.Context(), s.Cfg))
+
 	sessionCookie, _ := r.Cookie(auth.SessionCookieName)
 	if sessionCookie == nil {
 		return r, nil
@@ -148,7 +183,14 @@ func (s *Service) WithSession(r *http.Request) (*http.Request, error) {
 	if err != nil {
 		return r, nil
 	}
-	session, err := s.DB.GetSession(r.Context(), sessionID)
+	session, err := s.DB.GetSession(r.Context(), database.GetSessionParams{
+		ID:                    sessionID,
+		RememberedIdleTimeout: int32(s.Cfg.RememberedSessionIdleTimeout.Seconds()),
+		IdleTimeout:           int32(s.Cfg.SessionIdleTimeout.Seconds()),
+		RememberedMaxAge:      int32(s.Cfg.RememberedSessionMaxAge.Seconds()),
+		MaxAge:                int32(s.Cfg.SessionMaxAge.Seconds()),
+		PermissionsMaxAge:     int32(s.Cfg.PermissionsRefresh.Seconds()),
+	})
 	if err == pgx.ErrNoRows {
 		return r, nil
 	}
@@ -158,22 +200,51 @@ func (s *Service) WithSession(r *http.Request) (*http.Request, error) {
 	var permissions hive.Permissions
 	err = json.Unmarshal(session.Permissions, &permissions)
 	if err != nil {
-		slog.Error("Got invalid json in permissions in database. Defaulting to empty permission set.", "permissions", session.Permissions, "error", err)
+		slog.ErrorContext(r.Context(), "Got invalid json in permissions in database. Defaulting to empty permission set.", "permissions", session.Permissions, "error", err)
+	}
+	if session.Kthid.Valid && session.PermissionsStale && !session.Impersonator.Valid {
+		// This request gets the old permissions, so that it isn't held up by Hive.
+		claimed, err := s.DB.ClaimSessionPermissionsRefresh(r.Context(), database.ClaimSessionPermissionsRefreshParams{
+			Kthid:             session.Kthid,
+			PermissionsMaxAge: int32(s.Cfg.PermissionsRefresh.Seconds()),
+		})
+		if err != nil {
+			return r, err
+		}
+		if claimed > 0 {
+			go s.refreshSessionPermissionsInBackground(context.WithoutCancel(r.Context()), session.Kthid.String)
+		}
 	}
 
 	ctx := context.WithValue(r.Context(), hive.PermissionsCtxKey{}, permissions)
+	ctx = context.WithValue(ctx, models.SessionIDCtxKey{}, sessionID)
 
 	if session.Kthid.Valid {
 		user, err := s.GetUser(r.Context(), session.Kthid.String)
 		if err != nil {
 			return r, err
 		}
+		if user == nil {
+			// The user has been deleted since logging in.
+			return r, nil
+		}
 		ctx = context.WithValue(ctx, models.UserCtxKey{}, user)
+		logging.SetKTHID(ctx, user.KTHID)
+		if session.Impersonator.Valid {
+			// Admin routes call this a second time with the same request, which should only be logged once.
+			_, logged := r.Context().Value(models.ImpersonatorCtxKey{}).(string)
+			ctx = context.WithValue(ctx, models.ImpersonatorCtxKey{}, session.Impersonator.String)
+			if !logged {
+				s.Audit(r.WithContext(ctx), "impersonation.request", session.Kthid.String, nil, map[string]any{
+					"request": r.Method + " " + r.URL.Path,
+				})
+			}
+		}
 	} else if len(session.GuestData) > 0 {
 		var guestUser models.GuestUser
 		err = json.Unmarshal(session.GuestData, &guestUser)
 		if err != nil {
-			slog.Error("Got invalid json in guest_data in database.", "guest_data", session.GuestData, "error", err)
+			slog.ErrorContext(r.Context(), "Got invalid json in guest_data in database.", "guest_data", session.GuestData, "error", err)
 			return r, nil
 		}
 		ctx = context.WithValue(ctx, models.GuestUserCtxKey{}, &guestUser)
@@ -182,6 +253,30 @@ func (s *Service) WithSession(r *http.Request) (*http.Request, error) {
 	return r.WithContext(ctx), nil
 }
 
+func (s *Service) refreshSessionPermissionsInBackground(ctx context.Context, kthid string) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if _, err := s.RefreshSessionPermissions(ctx, kthid); err != nil {
+		slog.WarnContext(ctx, "Could not refresh session permissions, keeping the old ones", "kthid", kthid, "error", err)
+	}
+}
+
+// RefreshSessionPermissions fetches the user's SSO permissions from Hive and
+// stores them in all of the user's sessions.
+func (s *Service) RefreshSessionPermissions(ctx context.Context, kthid string) (hive.Permissions, error) {
+	perms, err := s.Hive.GetSSOPermissions(ctx, kthid)
+	if err != nil {
+		return hive.Permissions{}, err
+	}
+	if err := s.DB.SetSessionPermissionsForUser(ctx, database.SetSessionPermissionsForUserParams{
+		Kthid:       pgtype.Text{String: kthid, Valid: true},
+		Permissions: marshalPermissions(perms),
+	}); err != nil {
+		return hive.Permissions{}, err
+	}
+	return perms, nil
+}
+
 func (s *Service) GetLoggedInUser(r *http.Request) *models.User {
 	user := r.Context().Value(models.UserCtxKey{})
 	if user == nil {
@@ -199,18 +294,23 @@ func (s *Service) GetLoggedInGuestUser(r *http.Request) *models.GuestUser {
 }
 
 func (s *Service) Logout(w http.ResponseWriter, r *http.Request) httputil.ToResponse {
+	if s.GetImpersonator(r) != "" {
+		return s.StopImpersonating(w, r)
+	}
 	sessionCookie, _ := r.Cookie(auth.SessionCookieName)
 	if sessionCookie != nil {
 		sessionID, err := uuid.Parse(sessionCookie.Value)
-		if err != nil {
+		if err == nil {
 			if err := s.DB.RemoveSession(r.Context(), sessionID); err != nil {
 				return err
 			}
+			if user := s.GetLoggedInUser(r); user != nil {
+				s.Audit(r, "logout", user.KTHID, map[string]any{"session": sessionID}, nil)
+			}
 		}
 	}
-	http.SetCookie(w, &http.Cookie{Name: auth.SessionCookieName, MaxAge: -1})
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-	return nil
+	http.SetCookie(w, &http.Cookie{Name: auth.SessionCookieName, MaxAge: -1, Path: "/"})
+	return httputil.Redirect("/")
 }
 
 func (s *Service) RedirectToLogin(w http.ResponseWriter, r *http.Request, nextURL string) {
@@ -227,12 +327,12 @@ func (s *Service) FinishAccountRequestKTH(w http.ResponseWriter, r *http.Request
 		return httputil.BadRequest("Invalid uuid")
 	}
 
-	person, err := kthldap.Lookup(r.Context(), kthid)
+	person, err := s.LDAP.Lookup(r.Context(), kthid)
 	if err != nil {
 		return err
 	}
 	if person == nil {
-		slog.Error("Could not find user in ldap", "kthid", kthid)
+		slog.ErrorContext(r.Context(), "Could not find user in ldap", "kthid", kthid)
 		return errors.New("Could not find user in ldap")
 	}
 
@@ -246,8 +346,9 @@ func (s *Service) FinishAccountRequestKTH(w http.ResponseWriter, r *http.Request
 	}); err != nil {
 		return err
 	}
+	s.AuditAs(r, kthid, "account-request.finish", requestID.String(), nil, map[string]any{"kthid": kthid})
 
-	if err := email.Send(
+	if err := s.Email.Send(
 		r.Context(),
 		"d-sys@datasektionen.se",
 		"Datasektionen Account Requested by "+person.KTHID,
@@ -255,7 +356,7 @@ func (s *Service) FinishAccountRequestKTH(w http.ResponseWriter, r *http.Request
 			<p>A new account request has been made by %s %s (%s).</p><a href="https://sso.datasektionen.se/admin/account-requests">sso.datasektionen.se/admin/account-requests</a>
 		`, person.FirstName, person.FamilyName, person.KTHID)),
 	); err != nil {
-		slog.Error("Could not send account request email", "kthid", kthid, "error", err)
+		slog.ErrorContext(r.Context(), "Could not send account request email", "kthid", kthid, "error", err)
 	}
 
 	http.SetCookie(w, &http.Cookie{Name: "account-request-id", MaxAge: -1, Path: "/"})
@@ -286,12 +387,12 @@ func (s *Service) FinishInvite(w http.ResponseWriter, r *http.Request, kthid str
 	if inv.MaxUses.Valid && inv.CurrentUses >= inv.MaxUses.Int32 {
 		return httputil.BadRequest("This invite has reached its usage limit")
 	}
-	person, err := kthldap.Lookup(r.Context(), kthid)
+	person, err := s.LDAP.Lookup(r.Context(), kthid)
 	if err != nil {
 		return err
 	}
 	if person == nil {
-		slog.Error("Could not find user in ldap", "kthid", kthid, "invite id", id)
+		slog.ErrorContext(r.Context(), "Could not find user in ldap", "kthid", kthid, "invite id", id)
 		return errors.New("Could not find user in ldap")
 	}
 	tx, err := s.DB.Begin(r.Context())
@@ -315,6 +416,7 @@ func (s *Service) FinishInvite(w http.ResponseWriter, r *http.Request, kthid str
 		return err
 	}
 	http.SetCookie(w, &http.Cookie{Name: "invite", MaxAge: -1})
-	slog.Info("User invite link used", "kthid", kthid, "invite-id", inv.ID)
-	return s.LoginUser(r.Context(), kthid, true)
+	slog.InfoContext(r.Context(), "User invite link used", "kthid", kthid, "invite-id", inv.ID)
+	s.AuditAs(r, kthid, "invite.use", inv.ID.String(), nil, map[string]any{"kthid": kthid})
+	return s.LoginUser(r, kthid, models.LoginMethodKTH, true)
 }